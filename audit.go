@@ -0,0 +1,211 @@
+package sqlds
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// AuditSource is where the result of an audited query came from.
+type AuditSource string
+
+const (
+	// AuditSourceDatabase is a query executed against the database.
+	AuditSourceDatabase AuditSource = "database"
+	// AuditSourceCache is a query answered from the result cache.
+	AuditSourceCache AuditSource = "cache"
+	// AuditSourceDedup is a query that shared the result of an identical
+	// query running at the same time.
+	AuditSourceDedup AuditSource = "dedup"
+)
+
+// AuditEvent records a query answered by SQLDatasource.
+type AuditEvent struct {
+	Time          time.Time `json:"time"`
+	DatasourceUID string    `json:"datasourceUid"`
+	// User is the login of the Grafana user the query ran for, if known.
+	User  string `json:"user,omitempty"`
+	OrgID int64  `json:"orgId"`
+	RefID string `json:"refId"`
+	// SQL is the query after interpolation, as sent to the database.
+	SQL string `json:"sql"`
+	// ConnectionKeyHash identifies the connection the query ran on.
+	ConnectionKeyHash string        `json:"connectionKeyHash"`
+	Duration          time.Duration `json:"-"`
+	Rows              int64         `json:"rows"`
+	Status            Status        `json:"status"`
+	// ErrorSource is set when the query failed.
+	ErrorSource backend.ErrorSource `json:"errorSource,omitempty"`
+	// Retries is how many times the query was retried after failing.
+	Retries int `json:"retries"`
+	// Executed reports whether the query ran against the database. It is
+	// false when the result came from the result cache or another query.
+	Executed bool `json:"executed"`
+	// Source is where the result came from.
+	Source AuditSource `json:"source"`
+	// Dialect is how the database reads quoted text in SQL, for redacting
+	// it.
+	Dialect SQLDialect `json:"-"`
+}
+
+// SQLDialect is how a database reads quoted text in SQL, as configured by
+// its DriverSettings.
+type SQLDialect struct {
+	// BackslashEscapes is DriverSettings.BackslashEscapes.
+	BackslashEscapes bool
+	// DoubleQuotedStrings is DriverSettings.DoubleQuotedStrings.
+	DoubleQuotedStrings bool
+}
+
+// MarshalJSON encodes the event with its duration in milliseconds.
+func (e AuditEvent) MarshalJSON() ([]byte, error) {
+	type event AuditEvent
+	return json.Marshal(struct {
+		event
+		DurationMs float64 `json:"durationMs"`
+	}{event(e), float64(e.Duration) / float64(time.Millisecond)})
+}
+
+// QueryAuditor is called by SQLDatasource once for every query it answers.
+// Queries answered from the result cache or shared with an identical running
+// query are audited too, with Executed false and their Source, so every
+// access to the data is recorded. AuditQuery is called on the query's
+// goroutine and should not block.
+type QueryAuditor interface {
+	AuditQuery(ctx context.Context, event AuditEvent)
+}
+
+// JSONLinesAuditor is a QueryAuditor that writes every event as a line of
+// JSON, to a writer or to a logger.
+type JSONLinesAuditor struct {
+	// Redact (optional) rewrites the SQL of every event, read as the
+	// event's Dialect, before it is written, e.g. RedactSQL to leave out
+	// literal values.
+	Redact func(sql string, dialect SQLDialect) string
+
+	mu     sync.Mutex
+	w      io.Writer
+	logger log.Logger
+}
+
+// NewJSONLinesAuditor returns a JSONLinesAuditor writing to w.
+func NewJSONLinesAuditor(w io.Writer) *JSONLinesAuditor {
+	return &JSONLinesAuditor{w: w}
+}
+
+// OpenAuditFile returns a JSONLinesAuditor appending to the file at path,
+// creating it if needed. The file is closed by Close.
+func OpenAuditFile(path string) (*JSONLinesAuditor, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONLinesAuditor(f), nil
+}
+
+// NewLogAuditor returns a JSONLinesAuditor logging every event at info level
+// through logger.
+func NewLogAuditor(logger log.Logger) *JSONLinesAuditor {
+	return &JSONLinesAuditor{logger: logger}
+}
+
+// AuditQuery implements QueryAuditor.
+func (a *JSONLinesAuditor) AuditQuery(_ context.Context, event AuditEvent) {
+	if a.Redact != nil {
+		event.SQL = a.Redact(event.SQL, event.Dialect)
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		backend.Logger.Error("failed encoding query audit event", "error", err.Error())
+		return
+	}
+
+	if a.logger != nil {
+		a.logger.Info("query audit", "event", string(line))
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.w.Write(append(line, '\n')); err != nil {
+		backend.Logger.Error("failed writing query audit event", "error", err.Error())
+	}
+}
+
+// Close closes the writer of the auditor if it is an io.Closer, such as the
+// file opened by OpenAuditFile.
+func (a *JSONLinesAuditor) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if c, ok := a.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// auditQuery hands a query answered from source, for the connection stored
+// under connectionKey, to the QueryAuditor, if one is set.
+func (ds *SQLDatasource) auditQuery(ctx context.Context, q *Query, connectionKey string, source AuditSource, start time.Time, rows int64, retries int, err error) {
+	if ds.QueryAuditor == nil {
+		return
+	}
+
+	pluginCtx := backend.PluginConfigFromContext(ctx)
+	settings := ds.DriverSettings()
+	event := AuditEvent{
+		Time:              start,
+		DatasourceUID:     ds.connector.UID,
+		OrgID:             pluginCtx.OrgID,
+		RefID:             q.RefID,
+		SQL:               q.RawSQL,
+		ConnectionKeyHash: connectionKeyHash(connectionKey),
+		Duration:          time.Since(start),
+		Rows:              rows,
+		Status:            StatusOK,
+		Retries:           retries,
+		Executed:          source == AuditSourceDatabase,
+		Source:            source,
+		Dialect: SQLDialect{
+			BackslashEscapes:    settings.BackslashEscapes,
+			DoubleQuotedStrings: settings.DoubleQuotedStrings,
+		},
+	}
+	if pluginCtx.User != nil {
+		event.User = pluginCtx.User.Login
+	}
+	if err != nil {
+		event.Status = StatusError
+		event.ErrorSource = ErrorSource(err)
+	}
+	ds.QueryAuditor.AuditQuery(ctx, event)
+}
+
+// RedactSQL replaces the string and numeric literals in sql with
+// placeholders, leaving its structure, identifiers and comments intact.
+// Positional parameters such as $1 are kept. sql is read as dialect reads it,
+// so backslash-escaped quotes do not end a string early, and double-quoted
+// text is redacted when the dialect treats it as a string.
+func RedactSQL(sql string, dialect SQLDialect) string {
+	var b strings.Builder
+	b.Grow(len(sql))
+	for _, token := range lexSQL(sql, dialect.BackslashEscapes) {
+		switch {
+		case token.kind == sqlString:
+			b.WriteString("'?'")
+		case token.kind == sqlQuotedIdent && dialect.DoubleQuotedStrings && token.text[0] == '"':
+			b.WriteString(`"?"`)
+		case token.kind == sqlNumber:
+			b.WriteByte('?')
+		default:
+			b.WriteString(token.text)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,250 @@
+package sqlds_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/sqlds/v5"
+	"github.com/grafana/sqlds/v5/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingAuditor struct {
+	mu     sync.Mutex
+	events []sqlds.AuditEvent
+}
+
+func (a *recordingAuditor) AuditQuery(_ context.Context, event sqlds.AuditEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.events = append(a.events, event)
+}
+
+func TestQueryAuditor(t *testing.T) {
+	rows := test.Data{
+		Cols: []test.Column{{Name: "v", DataType: "INTEGER", Kind: int64(0)}},
+		Rows: [][]any{{int64(1)}, {int64(2)}, {int64(3)}},
+	}
+	name := "query-auditor"
+	driver, _ := test.NewDriver(name, rows, nil, test.DriverOpts{}, nil)
+	ds := sqlds.NewDatasource(driver)
+	auditor := &recordingAuditor{}
+	ds.QueryAuditor = auditor
+
+	req, settings := setupQueryRequest(name, "{}")
+	_, err := ds.NewDatasource(context.Background(), settings)
+	require.NoError(t, err)
+
+	ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{
+		OrgID: 3,
+		User:  &backend.User{Login: "alice"},
+	})
+	res, err := ds.QueryData(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, res.Responses["foo"].Error)
+
+	require.Len(t, auditor.events, 1)
+	event := auditor.events[0]
+	assert.Equal(t, name, event.DatasourceUID)
+	assert.Equal(t, "alice", event.User)
+	assert.Equal(t, int64(3), event.OrgID)
+	assert.Equal(t, "foo", event.RefID)
+	assert.Equal(t, "foo", event.SQL)
+	assert.NotEmpty(t, event.ConnectionKeyHash)
+	assert.Equal(t, int64(3), event.Rows)
+	assert.Equal(t, sqlds.StatusOK, event.Status)
+	assert.Empty(t, event.ErrorSource)
+	assert.Equal(t, 0, event.Retries)
+	assert.True(t, event.Executed)
+	assert.Equal(t, sqlds.AuditSourceDatabase, event.Source)
+}
+
+func TestQueryAuditor_Dialect(t *testing.T) {
+	rows := test.Data{
+		Cols: []test.Column{{Name: "v", DataType: "INTEGER", Kind: int64(0)}},
+		Rows: [][]any{{int64(1)}},
+	}
+	name := "query-auditor-dialect"
+	driver, _ := test.NewDriver(name, rows, nil, test.DriverOpts{}, nil)
+	ds := sqlds.NewDatasource(driver)
+	auditor := &recordingAuditor{}
+	ds.QueryAuditor = auditor
+
+	req, settings := setupQueryRequest(name, `{ "backslashEscapes": true, "doubleQuotedStrings": true }`)
+	_, err := ds.NewDatasource(context.Background(), settings)
+	require.NoError(t, err)
+
+	_, err = ds.QueryData(context.Background(), req)
+	require.NoError(t, err)
+
+	require.Len(t, auditor.events, 1)
+	assert.Equal(t, sqlds.SQLDialect{BackslashEscapes: true, DoubleQuotedStrings: true}, auditor.events[0].Dialect)
+}
+
+func TestQueryAuditor_CacheHits(t *testing.T) {
+	rows := test.Data{
+		Cols: []test.Column{{Name: "v", DataType: "INTEGER", Kind: int64(0)}},
+		Rows: [][]any{{int64(1)}, {int64(2)}},
+	}
+	name := "query-auditor-cache"
+	driver, _ := test.NewDriver(name, rows, nil, test.DriverOpts{}, nil)
+	ds := sqlds.NewDatasource(driver)
+	auditor := &recordingAuditor{}
+	ds.QueryAuditor = auditor
+
+	req, settings := setupQueryRequest(name, `{ "resultCacheTTL": 60000000000 }`)
+	_, err := ds.NewDatasource(context.Background(), settings)
+	require.NoError(t, err)
+
+	for range 2 {
+		_, err = ds.QueryData(context.Background(), req)
+		require.NoError(t, err)
+	}
+
+	require.Len(t, auditor.events, 2)
+	assert.True(t, auditor.events[0].Executed)
+	assert.Equal(t, sqlds.AuditSourceDatabase, auditor.events[0].Source)
+	assert.False(t, auditor.events[1].Executed)
+	assert.Equal(t, sqlds.AuditSourceCache, auditor.events[1].Source)
+	assert.Equal(t, int64(2), auditor.events[1].Rows)
+	assert.Equal(t, sqlds.StatusOK, auditor.events[1].Status)
+}
+
+func TestQueryAuditor_DeduplicatedQueries(t *testing.T) {
+	rows := test.Data{
+		Cols: []test.Column{{Name: "v", DataType: "INTEGER", Kind: int64(0)}},
+		Rows: [][]any{{int64(1)}},
+	}
+	name := "query-auditor-dedup"
+	driver, _ := test.NewDriver(name, rows, nil, test.DriverOpts{QueryDelay: 1}, nil)
+	ds := sqlds.NewDatasource(driver)
+	ds.EnableQueryDeduplication = true
+	auditor := &recordingAuditor{}
+	ds.QueryAuditor = auditor
+
+	req, settings := setupQueryRequest(name, "{}")
+	_, err := ds.NewDatasource(context.Background(), settings)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ds.QueryData(context.Background(), req)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, auditor.events, 2)
+	sources := map[sqlds.AuditSource]bool{}
+	for _, event := range auditor.events {
+		sources[event.Source] = event.Executed
+	}
+	assert.Equal(t, map[sqlds.AuditSource]bool{sqlds.AuditSourceDatabase: true, sqlds.AuditSourceDedup: false}, sources)
+}
+
+func TestQueryAuditor_Retries(t *testing.T) {
+	opts := test.DriverOpts{
+		QueryError: transientError{},
+	}
+	name := "query-auditor-retries"
+	driver, _ := test.NewDriver(name, test.Data{}, nil, opts, nil)
+	ds := sqlds.NewDatasource(driver)
+	auditor := &recordingAuditor{}
+	ds.QueryAuditor = auditor
+
+	req, settings := setupQueryRequest(name, `{ "retries": 2, "retryOn": ["transient"] }`)
+	_, err := ds.NewDatasource(context.Background(), settings)
+	require.NoError(t, err)
+
+	_, err = ds.QueryData(context.Background(), req)
+	require.NoError(t, err)
+
+	require.Len(t, auditor.events, 1)
+	event := auditor.events[0]
+	assert.Equal(t, sqlds.StatusError, event.Status)
+	assert.Equal(t, backend.ErrorSourceDownstream, event.ErrorSource)
+	assert.Equal(t, 2, event.Retries)
+	assert.Empty(t, event.User)
+}
+
+func TestJSONLinesAuditor(t *testing.T) {
+	var buf bytes.Buffer
+	auditor := sqlds.NewJSONLinesAuditor(&buf)
+	auditor.Redact = sqlds.RedactSQL
+
+	auditor.AuditQuery(context.Background(), sqlds.AuditEvent{RefID: "A", SQL: "SELECT * FROM t WHERE name = 'bob'"})
+	auditor.AuditQuery(context.Background(), sqlds.AuditEvent{RefID: "B", SQL: "SELECT 1"})
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+	var event map[string]any
+	require.NoError(t, json.Unmarshal(lines[0], &event))
+	assert.Equal(t, "A", event["refId"])
+	assert.Equal(t, "SELECT * FROM t WHERE name = '?'", event["sql"])
+	assert.Contains(t, event, "durationMs")
+}
+
+func TestRedactSQL(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{sql: "SELECT 1", want: "SELECT ?"},
+		{sql: "SELECT * FROM t2 WHERE id = 42 AND v > 1.5e3", want: "SELECT * FROM t2 WHERE id = ? AND v > ?"},
+		{sql: "SELECT 'it''s', \"col 1\" FROM t", want: "SELECT '?', \"col 1\" FROM t"},
+		{sql: "SELECT $$secret$$, $1, $tag$x$tag$", want: "SELECT '?', $1, '?'"},
+		{sql: "SELECT a -- don't 7\nFROM t /* 'x' */", want: "SELECT a -- don't 7\nFROM t /* 'x' */"},
+		{sql: "SELECT 'unterminated", want: "SELECT '?'"},
+		{sql: `SELECT "a""1" FROM t WHERE x = :1 OR y = ?`, want: `SELECT "a""1" FROM t WHERE x = :1 OR y = ?`},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, sqlds.RedactSQL(tt.sql, sqlds.SQLDialect{}), tt.sql)
+	}
+}
+
+func TestRedactSQL_Dialect(t *testing.T) {
+	mysql := sqlds.SQLDialect{BackslashEscapes: true, DoubleQuotedStrings: true}
+	tests := []struct {
+		name    string
+		sql     string
+		dialect sqlds.SQLDialect
+		want    string
+	}{
+		{
+			name:    "backslash escaped quote",
+			sql:     `SELECT * FROM t WHERE v = 'it\'s my secret; really'`,
+			dialect: mysql,
+			want:    `SELECT * FROM t WHERE v = '?'`,
+		},
+		{
+			name:    "double quoted string",
+			sql:     `SELECT * FROM t WHERE v = "my \"secret\""`,
+			dialect: mysql,
+			want:    `SELECT * FROM t WHERE v = "?"`,
+		},
+		{
+			name: "double quoted identifier",
+			sql:  `SELECT "secret" FROM t`,
+			want: `SELECT "secret" FROM t`,
+		},
+		{
+			name:    "backticks stay identifiers",
+			sql:     "SELECT `col` FROM t",
+			dialect: mysql,
+			want:    "SELECT `col` FROM t",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, sqlds.RedactSQL(tt.sql, tt.dialect))
+		})
+	}
+}
@@ -2,9 +2,14 @@ package sqlds
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"os"
+	"reflect"
 	"strconv"
 	"sync"
 	"testing"
@@ -225,3 +230,77 @@ func BenchmarkResponse_ConcurrentSet(b *testing.B) {
 		})
 	}
 }
+
+// ---------------------------------------------------------------------------
+// getFrames — per-row scan and append cost of converting a result set.
+// ---------------------------------------------------------------------------
+
+// benchRowsConnector opens connections answering every query with n rows of
+// an int64, a float64, a string and a time column.
+type benchRowsConnector struct{ n int }
+
+func (c benchRowsConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return benchRowsConn(c), nil
+}
+func (benchRowsConnector) Driver() driver.Driver { return nil }
+
+type benchRowsConn struct{ n int }
+
+func (benchRowsConn) Prepare(_ string) (driver.Stmt, error) {
+	return nil, errors.New("not implemented")
+}
+func (benchRowsConn) Close() error              { return nil }
+func (benchRowsConn) Begin() (driver.Tx, error) { return nil, errors.New("not implemented") }
+
+func (c benchRowsConn) QueryContext(_ context.Context, _ string, _ []driver.NamedValue) (driver.Rows, error) {
+	return &benchRows{n: c.n}, nil
+}
+
+type benchRows struct{ n, i int }
+
+var benchRowsTypes = []reflect.Type{
+	reflect.TypeOf(int64(0)),
+	reflect.TypeOf(float64(0)),
+	reflect.TypeOf(""),
+	reflect.TypeOf(time.Time{}),
+}
+
+func (r *benchRows) Columns() []string { return []string{"id", "value", "name", "time"} }
+func (r *benchRows) Close() error      { return nil }
+
+func (r *benchRows) ColumnTypeScanType(index int) reflect.Type { return benchRowsTypes[index] }
+
+func (r *benchRows) Next(dest []driver.Value) error {
+	if r.i == r.n {
+		return io.EOF
+	}
+	dest[0] = int64(r.i)
+	dest[1] = float64(r.i)
+	dest[2] = "row"
+	dest[3] = time.Unix(int64(r.i), 0)
+	r.i++
+	return nil
+}
+
+func BenchmarkGetFrames(b *testing.B) {
+	for _, n := range []int{100, 10000} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			db := sql.OpenDB(benchRowsConnector{n})
+			defer db.Close()
+			query := &Query{RefID: "A", Format: FormatOptionTable}
+			b.ReportAllocs()
+			for b.Loop() {
+				rows, err := db.QueryContext(context.Background(), "SELECT")
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := getFrames(rows, newRowBudget(-1), 0, nil, nil, query); err != nil {
+					b.Fatal(err)
+				}
+				if err := rows.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
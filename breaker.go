@@ -0,0 +1,193 @@
+package sqlds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const defaultCircuitBreakerOpenDuration = 30 * time.Second
+
+// ErrorCircuitOpen is returned without contacting the database while the circuit breaker for a connection is open
+var ErrorCircuitOpen = errors.New("circuit breaker open: the database is failing and requests are rejected until it recovers")
+
+// BreakerState is the state of the circuit breaker guarding a connection.
+type BreakerState string
+
+const (
+	// BreakerClosed lets every request through.
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen rejects every request until the open duration has passed.
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen lets a single probe request through. Its outcome
+	// closes or reopens the breaker.
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// circuitBreaker trips after a number of consecutive failures of a
+// connection, after which requests on it fail fast instead of each waiting
+// out a timeout against a dead database.
+type circuitBreaker struct {
+	threshold    int
+	openDuration time.Duration
+	now          func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	// openedAt is when the breaker last opened, or when the half-open probe
+	// was let through.
+	openedAt time.Time
+}
+
+func newCircuitBreaker(threshold int, openDuration time.Duration) *circuitBreaker {
+	if openDuration <= 0 {
+		openDuration = defaultCircuitBreakerOpenDuration
+	}
+	return &circuitBreaker{threshold: threshold, openDuration: openDuration, now: time.Now, state: BreakerClosed}
+}
+
+// allow reports whether a request may go through, returning ErrorCircuitOpen
+// if not. Once the open duration has passed, one request is let through as a
+// probe. A probe that never reports back is replaced by another after a
+// further open duration.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerClosed {
+		return nil
+	}
+	now := b.now()
+	if wait := b.openDuration - now.Sub(b.openedAt); wait > 0 {
+		return backend.DownstreamError(fmt.Errorf("%w (retry in %s)", ErrorCircuitOpen, wait.Round(time.Second)))
+	}
+	b.state = BreakerHalfOpen
+	b.openedAt = now
+	return nil
+}
+
+// isOpen reports whether requests are being rejected, without letting a
+// probe through.
+func (b *circuitBreaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state != BreakerClosed && b.now().Sub(b.openedAt) < b.openDuration
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = BreakerClosed
+	b.failures = 0
+}
+
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+func (b *circuitBreaker) currentState() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// connectError is a failure to open a connection, already counted against
+// the circuit breaker of its key by recordConnect.
+type connectError struct{ err error }
+
+func (e connectError) Error() string { return e.err.Error() }
+
+func (e connectError) Unwrap() error { return e.err }
+
+// breaker returns the circuit breaker of the connection stored under key, or
+// nil when DriverSettings.CircuitBreakerFailures disables circuit breaking.
+func (c *Connector) breaker(key string) *circuitBreaker {
+	if c.driverSettings.CircuitBreakerFailures <= 0 {
+		return nil
+	}
+	if b, ok := c.breakers.Load(key); ok {
+		return b.(*circuitBreaker)
+	}
+	b, _ := c.breakers.LoadOrStore(key, newCircuitBreaker(c.driverSettings.CircuitBreakerFailures, c.driverSettings.CircuitBreakerOpenDuration))
+	return b.(*circuitBreaker)
+}
+
+// allowRequest returns ErrorCircuitOpen while the breaker of key is open.
+func (c *Connector) allowRequest(key string) error {
+	if b := c.breaker(key); b != nil {
+		return b.allow()
+	}
+	return nil
+}
+
+// recordConnect records the outcome of opening or pinging the connection
+// stored under key. Every failure counts against the breaker, and marks the
+// endpoint of the connection unhealthy.
+func (c *Connector) recordConnect(key string, err error) {
+	if err != nil && c.endpoints != nil {
+		c.endpoints.markUnhealthy(c.endpointOf(key))
+	}
+	b := c.breaker(key)
+	if b == nil {
+		return
+	}
+	if err != nil {
+		b.failure()
+		return
+	}
+	b.success()
+}
+
+// recordQuery records the outcome of a query on the connection stored under
+// key. Only timeouts and errors the driver or RetryOn classify as retryable
+// count against the breaker: any other error still means the database
+// answered. Queries canceled by their caller are not counted, nor are
+// requests the open breaker rejected and failed reconnects, which
+// recordConnect already counted. Connection errors also mark the endpoint of
+// the connection unhealthy.
+func (c *Connector) recordQuery(key string, err error) {
+	if errors.As(err, new(connectError)) || errors.Is(err, ErrorCircuitOpen) {
+		return
+	}
+	c.recordEndpoint(key, err)
+	b := c.breaker(key)
+	if b == nil {
+		return
+	}
+	switch {
+	case err == nil:
+		b.success()
+	case errors.Is(err, context.Canceled):
+	case errors.Is(err, context.DeadlineExceeded):
+		b.failure()
+	default:
+		classifier, _ := c.driver.(RetryClassifier)
+		if classifyRetry(classifier, c.driverSettings.RetryOn, err) != RetryFail {
+			b.failure()
+			return
+		}
+		b.success()
+	}
+}
+
+// breakerStates returns the state of every circuit breaker, by connection
+// key.
+func (c *Connector) breakerStates() map[string]BreakerState {
+	states := map[string]BreakerState{}
+	c.breakers.Range(func(key, b any) bool {
+		states[key.(string)] = b.(*circuitBreaker).currentState()
+		return true
+	})
+	return states
+}
@@ -0,0 +1,174 @@
+package sqlds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestCircuitBreaker_States(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newCircuitBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	b.failure()
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected the breaker to stay closed below the threshold, got %v", err)
+	}
+	b.success()
+	b.failure()
+	if b.currentState() != BreakerClosed {
+		t.Fatal("expected a success to reset the consecutive failure count")
+	}
+
+	b.failure()
+	if b.currentState() != BreakerOpen {
+		t.Fatalf("expected the breaker to open, got %s", b.currentState())
+	}
+	if err := b.allow(); !errors.Is(err, ErrorCircuitOpen) {
+		t.Fatalf("expected ErrorCircuitOpen, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if b.isOpen() {
+		t.Fatal("expected isOpen to report false once the open duration passed")
+	}
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected a probe to be let through, got %v", err)
+	}
+	if b.currentState() != BreakerHalfOpen {
+		t.Fatalf("expected the breaker to be half-open, got %s", b.currentState())
+	}
+	if err := b.allow(); !errors.Is(err, ErrorCircuitOpen) {
+		t.Fatalf("expected only one probe at a time, got %v", err)
+	}
+
+	b.failure()
+	if b.currentState() != BreakerOpen {
+		t.Fatalf("expected a failed probe to reopen the breaker, got %s", b.currentState())
+	}
+
+	now = now.Add(time.Minute)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected a probe to be let through, got %v", err)
+	}
+	b.success()
+	if b.currentState() != BreakerClosed {
+		t.Fatalf("expected a successful probe to close the breaker, got %s", b.currentState())
+	}
+}
+
+func TestCircuitBreaker_LostProbe(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newCircuitBreaker(1, time.Minute)
+	b.now = func() time.Time { return now }
+
+	b.failure()
+	now = now.Add(time.Minute)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected a probe to be let through, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected a probe that never reported back to be replaced, got %v", err)
+	}
+}
+
+func TestConnector_RecordQuery(t *testing.T) {
+	c := &Connector{driverSettings: DriverSettings{CircuitBreakerFailures: 1, RetryOn: []string{"connection reset"}}}
+
+	tests := []struct {
+		name string
+		err  error
+		open bool
+	}{
+		{name: "success", err: nil},
+		{name: "query error", err: errors.New("syntax error")},
+		{name: "canceled", err: fmt.Errorf("query: %w", context.Canceled)},
+		{name: "timeout", err: fmt.Errorf("query: %w", context.DeadlineExceeded), open: true},
+		{name: "retryable", err: errors.New("connection reset by peer"), open: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.recordQuery(tt.name, tt.err)
+			if open := c.breaker(tt.name).isOpen(); open != tt.open {
+				t.Fatalf("expected open %v, got %v", tt.open, open)
+			}
+		})
+	}
+
+	states := c.breakerStates()
+	if len(states) != len(tests) || states["timeout"] != BreakerOpen || states["success"] != BreakerClosed {
+		t.Fatalf("unexpected breaker states %v", states)
+	}
+}
+
+func TestHealthChecker_BreakerDetailsHashKeys(t *testing.T) {
+	c := &Connector{driverSettings: DriverSettings{CircuitBreakerFailures: 1}}
+	c.recordQuery("uid-secret", context.DeadlineExceeded)
+
+	details := (&HealthChecker{Connector: c}).breakerDetails()
+	if strings.Contains(string(details), "uid-secret") {
+		t.Fatalf("expected the connection key to be hashed, got %s", details)
+	}
+	want := fmt.Sprintf(`{"circuitBreakers":{%q:%q}}`, connectionKeyHash("uid-secret"), BreakerOpen)
+	if string(details) != want {
+		t.Fatalf("expected %s, got %s", want, details)
+	}
+}
+
+func TestConnector_BreakerDisabled(t *testing.T) {
+	c := &Connector{}
+	c.recordQuery("key", context.DeadlineExceeded)
+	if err := c.allowRequest("key"); err != nil {
+		t.Fatalf("expected no breaker without CircuitBreakerFailures, got %v", err)
+	}
+	if n := len(c.breakerStates()); n != 0 {
+		t.Fatalf("expected no breakers, got %d", n)
+	}
+}
+
+func TestConnector_RecordQuerySkipsFailedReconnects(t *testing.T) {
+	c := &Connector{driverSettings: DriverSettings{CircuitBreakerFailures: 2, RetryOn: []string{"connection refused"}}}
+	err := errors.New("dial tcp: connection refused")
+
+	c.recordConnect("key", err)
+	c.recordQuery("key", fmt.Errorf("query: %w", backend.DownstreamError(connectError{err})))
+	if c.breaker("key").isOpen() {
+		t.Fatal("expected the failed reconnect to be counted once")
+	}
+	c.recordQuery("key", backend.DownstreamError(ErrorCircuitOpen))
+	if c.breaker("key").isOpen() {
+		t.Fatal("expected requests rejected by the breaker not to be counted")
+	}
+	c.recordQuery("key", err)
+	if !c.breaker("key").isOpen() {
+		t.Fatal("expected the query failure to open the breaker")
+	}
+}
+
+func TestConnector_ForgetsEvictedBreakers(t *testing.T) {
+	c, err := NewConnector(context.Background(), noopDriver{}, backend.DataSourceInstanceSettings{UID: "uid"}, true, WithCache(NewLRUCache(2, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.driverSettings.CircuitBreakerFailures = 1
+	c.recordQuery("uid-a", context.DeadlineExceeded)
+	c.storeDBConnection("uid-a", c.newCachedConnection(newCacheTestDB(), backend.DataSourceInstanceSettings{}, nil))
+	c.storeDBConnection("uid-b", c.newCachedConnection(newCacheTestDB(), backend.DataSourceInstanceSettings{}, nil))
+	if _, ok := c.breakerStates()["uid-a"]; ok {
+		t.Fatal("expected the breaker of the evicted connection to be dropped")
+	}
+
+	c.recordQuery("uid-b", context.DeadlineExceeded)
+	c.Dispose()
+	if n := len(c.breakerStates()); n != 0 {
+		t.Fatalf("expected Dispose to drop every breaker, got %d", n)
+	}
+}
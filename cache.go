@@ -1,7 +1,11 @@
 package sqlds
 
 import (
+	"container/list"
 	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
 )
 
 // ConnectionCache is the per-Connector cache contract for the
@@ -72,3 +76,260 @@ func (c *syncMapCache) Dispose() {
 	})
 	c.m.Clear()
 }
+
+// EvictionNotifier is an additional interface that could be implemented by a
+// ConnectionCache. This adds the ability to the Connector to drop the state it
+// keeps per connection, such as its circuit breaker, once the cache evicts the
+// connection.
+type EvictionNotifier interface {
+	// OnEvict registers f to be called with the key of every connection the
+	// cache evicts, after it is removed. It replaces any f registered before.
+	OnEvict(f func(key string))
+}
+
+// CacheStats describe the contents of a ConnectionCache.
+type CacheStats struct {
+	// Size is the number of cached connections.
+	Size int
+	// Evictions is the number of connections the cache evicted, and closed,
+	// since it was created. Connections closed by Dispose are not counted.
+	Evictions uint64
+}
+
+// EvictingCache is a ConnectionCache that evicts and closes connections idle
+// for longer than its TTL and, when bounded, the least recently used
+// connections over its capacity. Connections stored under a pinned key, such
+// as the bootstrap connection the Connector pins, are never evicted. Build
+// one with NewTTLCache or NewLRUCache.
+//
+// A connection is idle from the last Store or Load of its key. Idle
+// connections are found by a sweep goroutine, which Dispose stops, and by
+// Load, so an expired connection is never handed out between sweeps.
+type EvictingCache struct {
+	ttl      time.Duration
+	capacity int
+
+	mu        sync.Mutex
+	entries   map[string]*list.Element
+	lru       *list.List // of *evictingEntry, most recently used first
+	evictions uint64
+	onEvict   func(key string)
+	pinned    map[string]bool
+
+	stop        chan struct{}
+	done        chan struct{}
+	disposeOnce sync.Once
+}
+
+type evictingEntry struct {
+	key      string
+	conn     CachedConnection
+	lastUsed time.Time
+	// pinned entries are never evicted, see Pin.
+	pinned bool
+}
+
+// NewTTLCache returns an EvictingCache that evicts connections idle for
+// longer than ttl, without a bound on the number of connections. A ttl of
+// zero or less never expires connections.
+func NewTTLCache(ttl time.Duration) *EvictingCache {
+	return newEvictingCache(ttl, 0)
+}
+
+// NewLRUCache returns an EvictingCache holding at most capacity connections,
+// evicting the least recently used ones beyond it, that also evicts
+// connections idle for longer than idleTTL. A capacity of zero or less leaves
+// the cache unbounded, and an idleTTL of zero or less never expires
+// connections. The bootstrap connection counts toward the capacity but is
+// never evicted, so a capacity of one only ever holds it and the latest
+// connection.
+func NewLRUCache(capacity int, idleTTL time.Duration) *EvictingCache {
+	return newEvictingCache(idleTTL, capacity)
+}
+
+func newEvictingCache(ttl time.Duration, capacity int) *EvictingCache {
+	c := &EvictingCache{
+		ttl:      ttl,
+		capacity: capacity,
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+		pinned:   map[string]bool{},
+	}
+	if ttl > 0 {
+		c.stop = make(chan struct{})
+		c.done = make(chan struct{})
+		go c.sweep(max(ttl/2, time.Millisecond))
+	}
+	return c
+}
+
+// Pin exempts the connection stored under key, now or later, from eviction.
+// The Connector pins the key of its bootstrap connection.
+func (c *EvictingCache) Pin(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pinned[key] = true
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*evictingEntry).pinned = true
+	}
+}
+
+func (c *EvictingCache) Load(key string) (CachedConnection, bool) {
+	c.mu.Lock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return CachedConnection{}, false
+	}
+	entry := el.Value.(*evictingEntry)
+	now := time.Now()
+	if c.expired(entry, now) {
+		c.remove(el)
+		onEvict := c.onEvict
+		c.mu.Unlock()
+		closeEvicted(onEvict, entry)
+		return CachedConnection{}, false
+	}
+	entry.lastUsed = now
+	c.lru.MoveToFront(el)
+	c.mu.Unlock()
+	return entry.conn, true
+}
+
+func (c *EvictingCache) Store(key string, v CachedConnection) {
+	c.mu.Lock()
+	now := time.Now()
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*evictingEntry)
+		entry.conn, entry.lastUsed = v, now
+		c.lru.MoveToFront(el)
+		c.mu.Unlock()
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&evictingEntry{key: key, conn: v, lastUsed: now, pinned: c.pinned[key]})
+
+	var evicted []*evictingEntry
+	for el := c.lru.Back(); el != nil && c.capacity > 0 && c.lru.Len() > c.capacity; {
+		prev := el.Prev()
+		if entry := el.Value.(*evictingEntry); !entry.pinned && entry.key != key {
+			c.remove(el)
+			evicted = append(evicted, entry)
+		}
+		el = prev
+	}
+	onEvict := c.onEvict
+	c.mu.Unlock()
+	for _, entry := range evicted {
+		closeEvicted(onEvict, entry)
+	}
+}
+
+// Range calls f on a snapshot of the cache, so f may use the cache itself.
+// It does not count as use of the connections.
+func (c *EvictingCache) Range(f func(key string, v CachedConnection) bool) {
+	c.mu.Lock()
+	entries := make([]evictingEntry, 0, c.lru.Len())
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		entries = append(entries, *el.Value.(*evictingEntry))
+	}
+	c.mu.Unlock()
+	for _, entry := range entries {
+		if !f(entry.key, entry.conn) {
+			return
+		}
+	}
+}
+
+// Dispose stops the sweep goroutine, then closes and drops every
+// connection. It is safe to call more than once.
+func (c *EvictingCache) Dispose() {
+	c.disposeOnce.Do(func() {
+		if c.stop != nil {
+			close(c.stop)
+			<-c.done
+		}
+		c.mu.Lock()
+		entries := c.lru
+		c.entries = map[string]*list.Element{}
+		c.lru = list.New()
+		c.mu.Unlock()
+		for el := entries.Front(); el != nil; el = el.Next() {
+			_ = el.Value.(*evictingEntry).conn.Close()
+		}
+	})
+}
+
+// OnEvict registers f to be called with the key of every connection the cache
+// evicts, after closing it. Connections closed by Dispose are not evictions.
+func (c *EvictingCache) OnEvict(f func(key string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onEvict = f
+}
+
+// Stats returns the size and eviction count of the cache.
+func (c *EvictingCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Size: c.lru.Len(), Evictions: c.evictions}
+}
+
+func (c *EvictingCache) sweep(interval time.Duration) {
+	defer close(c.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case now := <-ticker.C:
+			c.evictExpired(now)
+		}
+	}
+}
+
+func (c *EvictingCache) evictExpired(now time.Time) {
+	c.mu.Lock()
+	var evicted []*evictingEntry
+	// The list is ordered by last use, so the idle entries are at its back,
+	// only interleaved with the never evicted pinned connections.
+	for el := c.lru.Back(); el != nil; {
+		prev := el.Prev()
+		entry := el.Value.(*evictingEntry)
+		if !entry.pinned {
+			if !c.expired(entry, now) {
+				break
+			}
+			c.remove(el)
+			evicted = append(evicted, entry)
+		}
+		el = prev
+	}
+	onEvict := c.onEvict
+	c.mu.Unlock()
+	for _, entry := range evicted {
+		closeEvicted(onEvict, entry)
+	}
+}
+
+func (c *EvictingCache) expired(entry *evictingEntry, now time.Time) bool {
+	return c.ttl > 0 && !entry.pinned && now.Sub(entry.lastUsed) > c.ttl
+}
+
+// remove drops el from the cache and counts it as evicted. c.mu must be held.
+func (c *EvictingCache) remove(el *list.Element) {
+	delete(c.entries, el.Value.(*evictingEntry).key)
+	c.lru.Remove(el)
+	c.evictions++
+}
+
+// closeEvicted closes the connection of an evicted entry, then passes its key
+// to onEvict when set.
+func closeEvicted(onEvict func(key string), entry *evictingEntry) {
+	if err := entry.conn.Close(); err != nil {
+		backend.Logger.Warn("closing evicted connection failed", "error", err.Error())
+	}
+	if onEvict != nil {
+		onEvict(entry.key)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 )
@@ -157,3 +158,144 @@ func TestSyncMapCache_ConcurrentAccess(t *testing.T) {
 		}
 	}
 }
+
+func TestTTLCache_EvictsIdleConnections(t *testing.T) {
+	c := NewTTLCache(20 * time.Millisecond)
+	defer c.Dispose()
+	c.Pin("uid-default")
+	bootstrap, idle := newCacheTestDB(), newCacheTestDB()
+	c.Store("uid-default", CachedConnection{db: bootstrap})
+	c.Store("uid-abc", CachedConnection{db: idle})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for c.Stats().Evictions == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the idle connection to be evicted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, ok := c.Load("uid-abc"); ok || !dbClosed(idle) {
+		t.Fatal("expected the evicted connection to be gone and closed")
+	}
+	if got, ok := c.Load("uid-default"); !ok || got.DB() != bootstrap {
+		t.Fatal("expected the bootstrap connection to never be evicted")
+	}
+	if got := c.Stats(); got.Size != 1 || got.Evictions != 1 {
+		t.Fatalf("got stats %+v, want size 1 and 1 eviction", got)
+	}
+}
+
+func TestTTLCache_LoadSkipsExpiredConnections(t *testing.T) {
+	c := newEvictingCache(time.Hour, 0)
+	defer c.Dispose()
+	db := newCacheTestDB()
+	c.Store("uid-abc", CachedConnection{db: db})
+	c.entries["uid-abc"].Value.(*evictingEntry).lastUsed = time.Now().Add(-2 * time.Hour)
+
+	if _, ok := c.Load("uid-abc"); ok {
+		t.Fatal("expected an expired connection not to be handed out")
+	}
+	if !dbClosed(db) {
+		t.Fatal("expected the expired connection to be closed")
+	}
+}
+
+func TestLRUCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewLRUCache(3, 0)
+	defer c.Dispose()
+	c.Pin("uid-default")
+	dbs := map[string]*sql.DB{}
+	for _, key := range []string{"uid-default", "uid-a", "uid-b"} {
+		dbs[key] = newCacheTestDB()
+		c.Store(key, CachedConnection{db: dbs[key]})
+	}
+	// Using uid-a makes uid-b the least recently used, after the bootstrap
+	// connection, which is never evicted.
+	if _, ok := c.Load("uid-a"); !ok {
+		t.Fatal("expected uid-a to be cached")
+	}
+	c.Store("uid-c", CachedConnection{db: newCacheTestDB()})
+
+	if _, ok := c.Load("uid-b"); ok || !dbClosed(dbs["uid-b"]) {
+		t.Fatal("expected uid-b to be evicted and closed")
+	}
+	for _, key := range []string{"uid-default", "uid-a", "uid-c"} {
+		if _, ok := c.Load(key); !ok {
+			t.Fatalf("expected %s to be cached", key)
+		}
+	}
+	if got := c.Stats(); got.Size != 3 || got.Evictions != 1 {
+		t.Fatalf("got stats %+v, want size 3 and 1 eviction", got)
+	}
+}
+
+func TestLRUCache_NeverEvictsBootstrap(t *testing.T) {
+	c := NewLRUCache(1, 0)
+	defer c.Dispose()
+	bootstrap := newCacheTestDB()
+	c.Store("uid-default", CachedConnection{db: bootstrap})
+	c.Pin("uid-default")
+	c.Store("uid-a", CachedConnection{db: newCacheTestDB()})
+	c.Store("uid-b", CachedConnection{db: newCacheTestDB()})
+
+	if got, ok := c.Load("uid-default"); !ok || got.DB() != bootstrap {
+		t.Fatal("expected the bootstrap connection to stay cached")
+	}
+	if _, ok := c.Load("uid-b"); !ok {
+		t.Fatal("expected the latest connection to stay cached")
+	}
+	if got := c.Stats(); got.Size != 2 || got.Evictions != 1 {
+		t.Fatalf("got stats %+v, want size 2 and 1 eviction", got)
+	}
+}
+
+func TestEvictingCache_OnlyPinnedKeysAreKept(t *testing.T) {
+	c := NewLRUCache(1, 0)
+	defer c.Dispose()
+	c.Pin("uid-default")
+	lookalike := newCacheTestDB()
+	c.Store("uid-default", CachedConnection{db: newCacheTestDB()})
+	c.Store("other-default", CachedConnection{db: lookalike})
+	c.Store("uid-a", CachedConnection{db: newCacheTestDB()})
+
+	if _, ok := c.Load("other-default"); ok || !dbClosed(lookalike) {
+		t.Fatal("expected a key merely ending in -default to be evicted")
+	}
+	if _, ok := c.Load("uid-default"); !ok {
+		t.Fatal("expected the pinned key to stay cached")
+	}
+}
+
+func TestConnector_PinsBootstrapConnection(t *testing.T) {
+	cache := NewLRUCache(1, 0)
+	conn, err := NewConnector(context.Background(), noopDriver{}, backend.DataSourceInstanceSettings{UID: "pin"}, true, WithCache(cache))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Dispose()
+	conn.storeDBConnection("pin-a", conn.newCachedConnection(newCacheTestDB(), backend.DataSourceInstanceSettings{}, nil))
+	conn.storeDBConnection("pin-b", conn.newCachedConnection(newCacheTestDB(), backend.DataSourceInstanceSettings{}, nil))
+	if _, ok := conn.getDBConnection(conn.defaultKey); !ok {
+		t.Fatal("expected the Connector to pin its bootstrap connection")
+	}
+}
+
+func TestEvictingCache_DisposeStopsSweepAndClosesEntries(t *testing.T) {
+	c := NewTTLCache(time.Hour)
+	db := newCacheTestDB()
+	c.Store("uid-default", CachedConnection{db: db})
+
+	c.Dispose()
+	select {
+	case <-c.done:
+	default:
+		t.Fatal("expected Dispose to stop the sweep goroutine")
+	}
+	if !dbClosed(db) {
+		t.Fatal("expected Dispose to close every connection")
+	}
+	if got := c.Stats(); got.Size != 0 || got.Evictions != 0 {
+		t.Fatalf("got stats %+v, want an empty cache without evictions", got)
+	}
+	c.Dispose()
+}
@@ -0,0 +1,228 @@
+package sqlds
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const (
+	queriesRoute     = "/queries"
+	cancelQueryRoute = "/queries/{id}/cancel"
+)
+
+// ErrorQueryNotFound is returned by the cancel resource when no running query has the requested id
+var ErrorQueryNotFound = errors.New("query not found")
+
+// RunningQuery describes a query that is currently running, as listed by the
+// /queries resource.
+type RunningQuery struct {
+	// ID identifies the query in the /queries/{id}/cancel resource.
+	ID    string `json:"id"`
+	RefID string `json:"refId"`
+	// Started is when the query was registered, before it was sent to the
+	// database.
+	Started time.Time `json:"started"`
+	// SQLHash is a hash of the interpolated SQL. The SQL itself is not
+	// exposed since it may contain sensitive values.
+	SQLHash string `json:"sqlHash"`
+	// ConnectionKeyHash identifies the connection the query runs on. The
+	// cache key itself is not exposed since it may be derived from
+	// connection arguments.
+	ConnectionKeyHash string `json:"connectionKeyHash"`
+	// User is the login of the Grafana user the query runs for, if known.
+	// Only that user and organization admins can list and cancel the query.
+	User string `json:"user,omitempty"`
+}
+
+// Canceler is an additional interface that could be implemented by driver.
+// This adds the ability to the driver to stop a query on the database server
+// when it is canceled through the /queries/{id}/cancel resource, for engines
+// that keep running a query after its client goes away unless it is
+// explicitly killed. CancelQuery is called in addition to canceling the
+// query's context, with the *sql.DB the query runs on.
+type Canceler interface {
+	CancelQuery(ctx context.Context, db *sql.DB, query RunningQuery) error
+}
+
+// adminRole is the Grafana organization role allowed to list and cancel the
+// queries of every user.
+const adminRole = "Admin"
+
+type runningQuery struct {
+	info   RunningQuery
+	db     *sql.DB
+	cancel context.CancelFunc
+}
+
+// runningQueryKey is the context key of the registry entry of a running
+// query.
+type runningQueryKey struct{}
+
+// queryRegistry tracks the queries running on a datasource instance so they
+// can be listed and canceled. The zero value is ready to use.
+type queryRegistry struct {
+	mu      sync.Mutex
+	next    uint64
+	queries map[string]*runningQuery
+}
+
+// register records q as running for the user of ctx and returns a context
+// canceled when the query is canceled through the registry, along with a
+// func that must be called once the query is done to remove it.
+func (r *queryRegistry) register(ctx context.Context, q *Query, connectionKey string, db *sql.DB) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	sum := sha256.Sum256([]byte(q.RawSQL))
+	var user string
+	if u := backend.PluginConfigFromContext(ctx).User; u != nil {
+		user = u.Login
+	}
+
+	r.mu.Lock()
+	r.next++
+	entry := &runningQuery{
+		info: RunningQuery{
+			ID:                fmt.Sprintf("%d-%s", r.next, q.RefID),
+			RefID:             q.RefID,
+			Started:           time.Now(),
+			SQLHash:           hex.EncodeToString(sum[:8]),
+			ConnectionKeyHash: connectionKeyHash(connectionKey),
+			User:              user,
+		},
+		db:     db,
+		cancel: cancel,
+	}
+	if r.queries == nil {
+		r.queries = map[string]*runningQuery{}
+	}
+	r.queries[entry.info.ID] = entry
+	r.mu.Unlock()
+
+	return context.WithValue(ctx, runningQueryKey{}, entry), func() {
+		r.mu.Lock()
+		delete(r.queries, entry.info.ID)
+		r.mu.Unlock()
+		cancel()
+	}
+}
+
+// setDB records db as the *sql.DB the query registered in ctx now runs on,
+// after it was retried on a new connection.
+func (r *queryRegistry) setDB(ctx context.Context, db *sql.DB) {
+	entry, ok := ctx.Value(runningQueryKey{}).(*runningQuery)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	entry.db = db
+	r.mu.Unlock()
+}
+
+// list returns the running queries user may see, oldest first.
+func (r *queryRegistry) list(user *backend.User) []RunningQuery {
+	r.mu.Lock()
+	res := make([]RunningQuery, 0, len(r.queries))
+	for _, entry := range r.queries {
+		if canManage(user, entry.info) {
+			res = append(res, entry.info)
+		}
+	}
+	r.mu.Unlock()
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Started.Before(res[j].Started) })
+	return res
+}
+
+// cancel cancels the context of the running query with the given id, if
+// user may cancel it, returning the query and the *sql.DB it runs on. The
+// query stays listed until it returns.
+func (r *queryRegistry) cancel(user *backend.User, id string) (RunningQuery, *sql.DB, bool) {
+	r.mu.Lock()
+	entry, ok := r.queries[id]
+	if !ok || !canManage(user, entry.info) {
+		r.mu.Unlock()
+		return RunningQuery{}, nil, false
+	}
+	info, db := entry.info, entry.db
+	r.mu.Unlock()
+
+	entry.cancel()
+	return info, db, true
+}
+
+// canManage reports whether user may list and cancel q: organization admins
+// may manage every query, other users only their own.
+func canManage(user *backend.User, q RunningQuery) bool {
+	if user == nil {
+		return false
+	}
+	return user.Role == adminRole || user.Login != "" && user.Login == q.User
+}
+
+// trackQuery registers q in the running query registry when
+// EnableQueryCancellation is set. The returned func must be called once the
+// query is done.
+func (ds *SQLDatasource) trackQuery(ctx context.Context, q *Query, connectionKey string, dbConn CachedConnection) (context.Context, func()) {
+	if !ds.EnableQueryCancellation {
+		return ctx, func() {}
+	}
+	return ds.queries.register(ctx, q, connectionKey, dbConn.db)
+}
+
+func (ds *SQLDatasource) listQueries(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	user := backend.PluginConfigFromContext(req.Context()).User
+	if user == nil {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+	rw.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(ds.queries.list(user)); err != nil {
+		handleError(rw, err)
+	}
+}
+
+func (ds *SQLDatasource) cancelQuery(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Queries of other users are reported as not found, so their ids
+	// cannot be probed.
+	id := req.PathValue("id")
+	info, db, ok := ds.queries.cancel(backend.PluginConfigFromContext(req.Context()).User, id)
+	if !ok {
+		rw.WriteHeader(http.StatusNotFound)
+		if _, err := rw.Write([]byte(fmt.Sprintf("%s: %s", ErrorQueryNotFound, id))); err != nil {
+			backend.Logger.Error(err.Error())
+		}
+		return
+	}
+
+	if ds.canceler != nil {
+		if err := ds.canceler.CancelQuery(req.Context(), db, info); err != nil {
+			backend.Logger.Error("driver failed to cancel query", "id", id, "error", err.Error())
+			handleError(rw, err)
+			return
+		}
+	}
+
+	rw.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(info); err != nil {
+		handleError(rw, err)
+	}
+}
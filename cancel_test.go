@@ -0,0 +1,199 @@
+package sqlds
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+type recordingCanceler struct {
+	canceled []RunningQuery
+	err      error
+}
+
+func (c *recordingCanceler) CancelQuery(_ context.Context, _ *sql.DB, q RunningQuery) error {
+	c.canceled = append(c.canceled, q)
+	return c.err
+}
+
+var (
+	alice = &backend.User{Login: "alice", Role: "Viewer"}
+	bob   = &backend.User{Login: "bob", Role: "Editor"}
+	admin = &backend.User{Login: "admin", Role: "Admin"}
+)
+
+// withUser returns ctx as a request or query of user carries it.
+func withUser(ctx context.Context, user *backend.User) context.Context {
+	return backend.WithPluginContext(ctx, backend.PluginContext{User: user})
+}
+
+// userRequest returns a resource request made by user.
+func userRequest(method, target string, user *backend.User) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	return req.WithContext(withUser(req.Context(), user))
+}
+
+func queryRoutesMux(t *testing.T, ds *SQLDatasource) *http.ServeMux {
+	t.Helper()
+	ds.EnableQueryCancellation = true
+	mux := http.NewServeMux()
+	if err := ds.registerRoutes(mux); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return mux
+}
+
+func TestQueryRegistry_RegisterListCancel(t *testing.T) {
+	var r queryRegistry
+	ctxA, doneA := r.register(withUser(context.Background(), alice), &Query{RefID: "A", RawSQL: "SELECT 1"}, "uid-default", nil)
+	_, doneB := r.register(withUser(context.Background(), bob), &Query{RefID: "B", RawSQL: "SELECT 2"}, "uid-default", nil)
+	defer doneB()
+
+	list := r.list(admin)
+	if len(list) != 2 {
+		t.Fatalf("expected 2 running queries, got %d", len(list))
+	}
+	if list[0].RefID != "A" || list[1].RefID != "B" {
+		t.Fatalf("expected queries oldest first, got %+v", list)
+	}
+	if list[0].ID == list[1].ID || list[0].SQLHash == list[1].SQLHash {
+		t.Fatalf("expected distinct ids and hashes, got %+v", list)
+	}
+	if list[0].ConnectionKeyHash != connectionKeyHash("uid-default") {
+		t.Fatalf("expected the connection key to be hashed, got %q", list[0].ConnectionKeyHash)
+	}
+
+	if own := r.list(alice); len(own) != 1 || own[0].RefID != "A" || own[0].User != "alice" {
+		t.Fatalf("expected alice to see only her query, got %+v", own)
+	}
+	if n := len(r.list(nil)); n != 0 {
+		t.Fatalf("expected no queries without a user, got %d", n)
+	}
+	if _, _, ok := r.cancel(bob, list[0].ID); ok {
+		t.Fatal("expected bob not to cancel alice's query")
+	}
+	if ctxA.Err() != nil {
+		t.Fatal("expected query A to keep running")
+	}
+	if _, _, ok := r.cancel(alice, list[0].ID); !ok {
+		t.Fatal("expected to find query A")
+	}
+	if !errors.Is(ctxA.Err(), context.Canceled) {
+		t.Fatalf("expected query A's context to be canceled, got %v", ctxA.Err())
+	}
+
+	doneA()
+	if _, _, ok := r.cancel(admin, list[0].ID); ok {
+		t.Fatal("expected a finished query to be removed")
+	}
+	if n := len(r.list(admin)); n != 1 {
+		t.Fatalf("expected 1 running query, got %d", n)
+	}
+}
+
+func TestTrackQuery_Disabled(t *testing.T) {
+	ds := &SQLDatasource{}
+	ctx, done := ds.trackQuery(context.Background(), &Query{RefID: "A"}, "uid-default", CachedConnection{})
+	defer done()
+	if ctx.Done() != nil {
+		t.Fatal("expected the context to be returned unchanged")
+	}
+	if n := len(ds.queries.list(admin)); n != 0 {
+		t.Fatalf("expected no tracked queries, got %d", n)
+	}
+}
+
+func TestQueryRoutes(t *testing.T) {
+	canceler := &recordingCanceler{}
+	ds := &SQLDatasource{canceler: canceler}
+	mux := queryRoutesMux(t, ds)
+
+	ctx, done := ds.trackQuery(withUser(context.Background(), alice), &Query{RefID: "A", RawSQL: "SELECT 1"}, "uid-default", CachedConnection{})
+	defer done()
+
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/queries", nil))
+	if resp.Code != http.StatusForbidden {
+		t.Fatalf("expected listing without a user to be forbidden, got %d", resp.Code)
+	}
+
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, userRequest(http.MethodGet, "/queries", bob))
+	if resp.Body.String() != "[]\n" {
+		t.Fatalf("expected bob to see no queries, got %s", resp.Body.String())
+	}
+
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, userRequest(http.MethodGet, "/queries", alice))
+	var list []RunningQuery
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(list) != 1 || list[0].RefID != "A" {
+		t.Fatalf("unexpected running queries %+v", list)
+	}
+
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/queries/"+list[0].ID+"/cancel", nil))
+	if resp.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected GET on cancel to be rejected, got %d", resp.Code)
+	}
+
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, userRequest(http.MethodPost, "/queries/"+list[0].ID+"/cancel", bob))
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("expected bob not to find alice's query, got %d", resp.Code)
+	}
+
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, userRequest(http.MethodPost, "/queries/"+list[0].ID+"/cancel", alice))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", resp.Code, resp.Body.String())
+	}
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		t.Fatal("expected the query's context to be canceled")
+	}
+	if len(canceler.canceled) != 1 || canceler.canceled[0].ID != list[0].ID {
+		t.Fatalf("expected the driver canceler to be called, got %+v", canceler.canceled)
+	}
+
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, userRequest(http.MethodPost, "/queries/unknown/cancel", admin))
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("expected unknown query to be not found, got %d", resp.Code)
+	}
+}
+
+func TestQueryRegistry_SetDB(t *testing.T) {
+	var r queryRegistry
+	first, second := &sql.DB{}, &sql.DB{}
+	ctx, done := r.register(withUser(context.Background(), alice), &Query{RefID: "A"}, "uid-default", first)
+	defer done()
+
+	r.setDB(ctx, second)
+	if _, db, _ := r.cancel(alice, r.list(alice)[0].ID); db != second {
+		t.Fatal("expected the query to be canceled on the connection it was retried on")
+	}
+}
+
+func TestQueryRoutes_CannotBeRedefined(t *testing.T) {
+	ds := &SQLDatasource{EnableQueryCancellation: true}
+	ds.CustomRoutes = map[string]func(http.ResponseWriter, *http.Request){
+		"/queries": func(w http.ResponseWriter, r *http.Request) {},
+	}
+	if err := ds.registerRoutes(http.NewServeMux()); err == nil {
+		t.Fatal("expected an error redefining /queries")
+	}
+
+	// Without cancellation enabled, plugins keep their own /queries route.
+	ds.EnableQueryCancellation = false
+	if err := ds.registerRoutes(http.NewServeMux()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package sqlds
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// gatedDriver counts calls to Connect, which block once gate is set until it
+// is closed.
+type gatedDriver struct {
+	noopDriver
+	connects atomic.Int32
+	gate     chan struct{}
+}
+
+func (d *gatedDriver) Connect(_ context.Context, _ backend.DataSourceInstanceSettings, _ json.RawMessage) (*sql.DB, error) {
+	d.connects.Add(1)
+	if d.gate != nil {
+		<-d.gate
+	}
+	return sql.OpenDB(noopConnector{}), nil
+}
+
+func newGatedConnector(t *testing.T) (*Connector, *gatedDriver) {
+	t.Helper()
+	d := &gatedDriver{}
+	conn, err := NewConnector(context.Background(), d, backend.DataSourceInstanceSettings{UID: "coalesce"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.gate = make(chan struct{})
+	return conn, d
+}
+
+// openGate closes the gate once a Connect is blocked on it, leaving the other
+// callers time to join it.
+func (d *gatedDriver) openGate(t *testing.T, want int32) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for d.connects.Load() < want {
+		if time.Now().After(deadline) {
+			t.Fatal("expected Connect to be called")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(d.gate)
+}
+
+func TestGetConnectionFromQuery_CoalescesConnect(t *testing.T) {
+	conn, d := newGatedConnector(t)
+	q := &Query{ConnectionArgs: json.RawMessage(`{"db":"a"}`)}
+
+	var wg sync.WaitGroup
+	dbs := make([]*sql.DB, 8)
+	for i := range dbs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, dbConn, err := conn.GetConnectionFromQuery(context.Background(), q)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer dbConn.Release()
+			dbs[i] = dbConn.db
+		}()
+	}
+	d.openGate(t, 2)
+	wg.Wait()
+
+	if got := d.connects.Load(); got != 2 {
+		t.Fatalf("expected the bootstrap and a single shared Connect, got %d", got)
+	}
+	cached, _ := conn.getDBConnection(keyWithConnectionArgs(conn.UID, q.ConnectionArgs))
+	for _, db := range dbs {
+		if db != cached.db {
+			t.Fatal("expected every caller to get the cached connection")
+		}
+	}
+}
+
+func TestReconnect_CoalescesConnect(t *testing.T) {
+	conn, d := newGatedConnector(t)
+	key, leased, err := conn.GetConnectionFromQuery(context.Background(), &Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leased.Release()
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := conn.Reconnect(context.Background(), leased, &Query{}, key); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	d.openGate(t, 2)
+	wg.Wait()
+
+	if got := d.connects.Load(); got != 2 {
+		t.Fatalf("expected the bootstrap and a single shared Connect, got %d", got)
+	}
+}
+
+func TestGetConnectionFromQuery_CanceledCallerDoesNotFailOthers(t *testing.T) {
+	conn, d := newGatedConnector(t)
+	q := &Query{ConnectionArgs: json.RawMessage(`{"db":"a"}`)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, _, err := conn.GetConnectionFromQuery(ctx, q)
+		first <- err
+	}()
+	second := make(chan error, 1)
+	go func() {
+		_, dbConn, err := conn.GetConnectionFromQuery(context.Background(), q)
+		dbConn.Release()
+		second <- err
+	}()
+
+	for d.connects.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the canceled caller to stop waiting, got %v", err)
+	}
+	close(d.gate)
+	if err := <-second; err != nil {
+		t.Fatalf("expected the other caller to get the connection, got %v", err)
+	}
+}
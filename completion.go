@@ -95,10 +95,21 @@ func (ds *SQLDatasource) registerRoutes(mux *http.ServeMux) error {
 	for route, handler := range defaultRoutes {
 		mux.HandleFunc(route, handler)
 	}
+	queryRoutes := map[string]func(http.ResponseWriter, *http.Request){}
+	if ds.EnableQueryCancellation {
+		queryRoutes[queriesRoute] = ds.listQueries
+		queryRoutes[cancelQueryRoute] = ds.cancelQuery
+	}
+	for route, handler := range queryRoutes {
+		mux.HandleFunc(route, handler)
+	}
 	for route, handler := range ds.CustomRoutes {
 		if _, ok := defaultRoutes[route]; ok {
 			return fmt.Errorf("unable to redefine %s, use the Completable interface instead", route)
 		}
+		if _, ok := queryRoutes[route]; ok {
+			return fmt.Errorf("unable to redefine %s while EnableQueryCancellation is set", route)
+		}
 		mux.HandleFunc(route, handler)
 	}
 	return nil
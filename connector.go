@@ -3,12 +3,15 @@ package sqlds
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"golang.org/x/sync/singleflight"
 )
 
 type Connector struct {
@@ -24,6 +27,37 @@ type Connector struct {
 	// are hit. The datasource enabling this should make sure connections are cached
 	// if necessary.
 	enableMultipleConnections bool
+	// metrics records connection retries.
+	metrics Metrics
+	// breakers holds a *circuitBreaker per connection cache key when
+	// DriverSettings.CircuitBreakerFailures is set. Entries are dropped when
+	// the cache evicts their connection, see forget.
+	breakers sync.Map
+	// pool is applied to every *sql.DB stored in the cache. It is resolved
+	// once in NewConnector, and nil for Connectors built without it, which
+	// leave their pools as Driver.Connect configured them.
+	pool *PoolSettings
+	// refs maps the *connRef of every open connection the Connector created
+	// to its *sql.DB, so Dispose can close those still leased.
+	refs sync.Map
+	// leases counts the outstanding leases of all connections.
+	// leasesReleased, when set, is closed once it drops to zero.
+	leaseMu        sync.Mutex
+	leases         int
+	leasesReleased chan struct{}
+	// swapMu makes the replacement of a connection by Reconnect atomic.
+	swapMu sync.Mutex
+	// connecting and reconnecting coalesce the concurrent creation and
+	// replacement of the connection cached under a key, so a single
+	// Driver.Connect runs for them.
+	connecting   singleflight.Group
+	reconnecting singleflight.Group
+	// keepalive is the background worker started when
+	// DriverSettings.KeepaliveInterval is set, and nil otherwise.
+	keepalive *keepalive
+	// endpoints tracks the endpoints of a MultiEndpointDriver, and is nil
+	// for drivers with a single endpoint.
+	endpoints *endpointSet
 }
 
 // ConnectorOption configures a Connector at construction time.
@@ -37,6 +71,12 @@ func WithCache(cache ConnectionCache) ConnectorOption {
 	return func(c *Connector) { c.cache = cache }
 }
 
+// WithMetricsProvider makes the Connector record its connection retries to
+// the collectors of p instead of the package-level ones.
+func WithMetricsProvider(p *MetricsProvider) ConnectorOption {
+	return func(c *Connector) { c.metrics = p.NewMetrics(c.metrics.DSName, c.metrics.DSType, EndpointHealth) }
+}
+
 func NewConnector(ctx context.Context, driver Driver, settings backend.DataSourceInstanceSettings, enableMultipleConnections bool, opts ...ConnectorOption) (*Connector, error) {
 	ds := driver.Settings(ctx, settings)
 	db, err := driver.Connect(ctx, settings, nil)
@@ -50,14 +90,25 @@ func NewConnector(ctx context.Context, driver Driver, settings backend.DataSourc
 		driverSettings:            ds,
 		defaultKey:                defaultKey(settings.UID),
 		enableMultipleConnections: enableMultipleConnections,
+		metrics:                   NewMetrics(settings.Name, settings.Type, EndpointHealth),
+		pool:                      resolvePoolSettings(ds.Pool, settings),
 	}
+	conn.endpoints = conn.newEndpointSet(settings)
 	for _, opt := range opts {
 		opt(conn)
 	}
 	if conn.cache == nil {
 		conn.cache = NewSyncMapCache()
 	}
-	conn.storeDBConnection(conn.defaultKey, CachedConnection{db, settings})
+	if notifier, ok := conn.cache.(EvictionNotifier); ok {
+		notifier.OnEvict(conn.forget)
+	}
+	if pinner, ok := conn.cache.(interface{ Pin(key string) }); ok {
+		pinner.Pin(conn.defaultKey)
+	}
+	conn.storeDBConnection(conn.defaultKey, conn.newCachedConnection(db, settings, nil))
+	conn.metrics.collectors().dbStats.add(conn)
+	conn.startKeepalive()
 	return conn, nil
 }
 
@@ -70,10 +121,12 @@ func (c *Connector) Connect(ctx context.Context, headers http.Header) (*CachedCo
 
 	if c.driverSettings.Retries == 0 {
 		err := c.connect(ctx, dbConn)
+		c.recordConnect(key, err)
 		return nil, err
 	}
 
 	err := c.connectWithRetries(ctx, dbConn, key, headers)
+	c.recordConnect(key, err)
 	return &dbConn, err
 }
 
@@ -83,23 +136,28 @@ func (c *Connector) connectWithRetries(ctx context.Context, conn CachedConnectio
 		applyHeaders(q, headers)
 	}
 
-	var db *sql.DB
 	var err error
+	classifier, _ := c.driver.(RetryClassifier)
+	policy := c.driverSettings.retryPolicy()
+	start := time.Now()
+	current := conn
+	decision := RetryWithReconnect
 	for i := 0; i < c.driverSettings.Retries; i++ {
-		db, err = c.Reconnect(ctx, conn, q, key)
-		if err != nil {
-			return err
-		}
-		conn := CachedConnection{
-			db:       db,
-			settings: conn.settings,
+		if decision == RetryWithReconnect {
+			leased, err := c.reconnect(ctx, current, q, key)
+			if err != nil {
+				return err
+			}
+			defer leased.Release()
+			current = leased
 		}
-		err = c.connect(ctx, conn)
+		err = c.connect(ctx, current)
 		if err == nil {
 			break
 		}
 
-		if !shouldRetry(c.driverSettings.RetryOn, err.Error()) {
+		decision = classifyRetry(classifier, c.driverSettings.RetryOn, err)
+		if decision == RetryFail {
 			break
 		}
 
@@ -107,8 +165,8 @@ func (c *Connector) connectWithRetries(ctx context.Context, conn CachedConnectio
 			break
 		}
 
-		if c.driverSettings.Pause > 0 {
-			time.Sleep(time.Duration(c.driverSettings.Pause * int(time.Second)))
+		if !waitRetry(ctx, policy, c.metrics, RetryReasonConnect, i+1, start) {
+			break
 		}
 		backend.Logger.Warn(fmt.Sprintf("connect failed: %s. Retrying %d times", err.Error(), i+1))
 	}
@@ -135,18 +193,107 @@ func (c *Connector) ping(ctx context.Context, conn CachedConnection) error {
 	return conn.db.PingContext(ctx)
 }
 
+// Reconnect replaces dbConn, cached under cacheKey, with a new connection
+// and returns it. The replaced connection is closed once its leases are
+// released. Concurrent calls for the same cacheKey share a single
+// Driver.Connect, and when another caller already replaced dbConn, its
+// replacement is returned instead of connecting again.
 func (c *Connector) Reconnect(ctx context.Context, dbConn CachedConnection, q *Query, cacheKey string) (*sql.DB, error) {
-	db, err := c.driver.Connect(ctx, dbConn.settings, q.ConnectionArgs)
+	leased, err := c.reconnect(ctx, dbConn, q, cacheKey)
 	if err != nil {
-		return nil, backend.DownstreamError(err)
+		return nil, err
 	}
+	leased.Release()
+	return leased.db, nil
+}
 
-	if err = dbConn.db.Close(); err != nil {
+// reconnect is Reconnect, returning the new connection leased. Concurrent
+// calls for the same cacheKey share a single replacement.
+func (c *Connector) reconnect(ctx context.Context, dbConn CachedConnection, q *Query, cacheKey string) (CachedConnection, error) {
+	conn, err := c.coalesce(ctx, &c.reconnecting, cacheKey, func(ctx context.Context) (CachedConnection, error) {
+		return c.replaceConnection(ctx, dbConn, q, cacheKey)
+	})
+	if err != nil {
+		return CachedConnection{}, err
+	}
+	return c.leaseShared(conn, cacheKey)
+}
+
+// replaceConnection connects again and stores the new connection under
+// cacheKey in place of dbConn, unless another caller already replaced it.
+func (c *Connector) replaceConnection(ctx context.Context, dbConn CachedConnection, q *Query, cacheKey string) (_ CachedConnection, err error) {
+	if current, ok := c.getDBConnection(cacheKey); ok && current.db != dbConn.db {
+		return current, nil
+	}
+	ctx, span := startSpan(ctx, "Reconnect", connectionKeyAttribute(cacheKey))
+	defer func() {
+		c.metrics.CollectReconnect(ctx, err)
+		endSpan(span, err)
+	}()
+
+	if b := c.breaker(cacheKey); b != nil && b.isOpen() {
+		return CachedConnection{}, backend.DownstreamError(ErrorCircuitOpen)
+	}
+	db, err := c.open(ctx, dbConn.settings, q.ConnectionArgs, c.endpointOf(cacheKey))
+	if err != nil {
+		c.recordConnect(cacheKey, err)
+		return CachedConnection{}, backend.DownstreamError(connectError{err})
+	}
+
+	replacement := c.newCachedConnection(db, dbConn.settings, q.ConnectionArgs)
+	c.swapMu.Lock()
+	if current, ok := c.getDBConnection(cacheKey); ok && current.db != dbConn.db {
+		c.swapMu.Unlock()
+		_ = replacement.Close()
+		return current, nil
+	}
+	c.storeDBConnection(cacheKey, replacement)
+	c.swapMu.Unlock()
+
+	if err := dbConn.Close(); err != nil {
 		backend.Logger.Warn(fmt.Sprintf("closing existing connection failed: %s", err.Error()))
 	}
+	return replacement, nil
+}
+
+// coalesce runs connect at most once at a time per key of g: callers
+// arriving while it runs wait for it and share the connection it returns.
+//
+// As with query deduplication, the shared run is detached from the
+// cancellation of whichever caller started it, keeping only its deadline, and
+// each caller still stops waiting when its own context is done.
+func (c *Connector) coalesce(ctx context.Context, g *singleflight.Group, key string, connect func(context.Context) (CachedConnection, error)) (CachedConnection, error) {
+	ch := g.DoChan(key, func() (interface{}, error) {
+		runCtx := context.WithoutCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			runCtx, cancel = context.WithDeadline(runCtx, deadline)
+			defer cancel()
+		}
+		return connect(runCtx)
+	})
+
+	select {
+	case <-ctx.Done():
+		return CachedConnection{}, backend.DownstreamError(ctx.Err())
+	case r := <-ch:
+		if r.Err != nil {
+			return CachedConnection{}, r.Err
+		}
+		return r.Val.(CachedConnection), nil
+	}
+}
 
-	c.storeDBConnection(cacheKey, CachedConnection{db, dbConn.settings})
-	return db, nil
+// leaseShared leases a connection returned by coalesce. Should it have been
+// evicted or replaced since, the one now cached under key is leased instead.
+func (c *Connector) leaseShared(conn CachedConnection, key string) (CachedConnection, error) {
+	if leased, ok := c.lease(conn); ok {
+		return leased, nil
+	}
+	if leased, ok := c.leaseDBConnection(key); ok {
+		return leased, nil
+	}
+	return CachedConnection{}, MissingDBConnection
 }
 
 // connCache returns the Connector's ConnectionCache, lazily installing the
@@ -166,47 +313,141 @@ func (c *Connector) getDBConnection(key string) (CachedConnection, bool) {
 }
 
 func (c *Connector) storeDBConnection(key string, dbConn CachedConnection) {
+	if c.pool != nil && dbConn.db != nil {
+		c.pool.apply(dbConn.db)
+	}
+	// The cache holds the connection itself, not the lease of whoever
+	// stores it.
+	dbConn.lease = nil
 	c.connCache().Store(key, dbConn)
 }
 
-// Dispose is called when an existing SQLDatasource needs to be replaced
+// resolvePoolSettings overrides the driver's pool settings with those of the
+// datasource's jsonData and fills in the defaults. Invalid jsonData settings
+// are logged and ignored rather than failing the datasource.
+func resolvePoolSettings(driverPool PoolSettings, settings backend.DataSourceInstanceSettings) *PoolSettings {
+	jsonPool, err := LoadPoolSettings(settings.JSONData)
+	if err != nil {
+		backend.Logger.Warn("ignoring connection pool settings", "error", err.Error())
+	}
+	pool := driverPool.override(jsonPool).withDefaults()
+	return &pool
+}
+
+// Dispose is called when an existing SQLDatasource needs to be replaced. It
+// waits up to DriverSettings.DisposeTimeout for the leases on its connections
+// to be released before closing them.
 func (c *Connector) Dispose() {
+	c.stopKeepalive()
+	c.metrics.collectors().dbStats.remove(c)
+	timeout := c.driverSettings.DisposeTimeout
+	if timeout <= 0 {
+		timeout = defaultDisposeTimeout
+	}
+	if leases := c.waitForLeases(timeout); leases > 0 {
+		// Leases outliving the timeout are usually connections from
+		// GetConnectionFromQuery that were never released.
+		backend.Logger.Warn("closing connections still in use; release every connection returned by GetConnectionFromQuery", "timeout", timeout.String(), "leases", leases)
+	}
 	c.connCache().Dispose()
+	c.closeLeased()
+	c.breakers.Clear()
 }
 
+// forget drops the state kept for the connection cached under key once the
+// cache evicted it.
+func (c *Connector) forget(key string) {
+	c.breakers.Delete(key)
+}
+
+// GetConnectionFromQuery returns the cache key and connection for q, opening
+// a connection for its ConnectionArgs if needed. The connection is leased:
+// call its Release method when done with it, so that a Reconnect or eviction
+// replacing it in the meantime can close it.
+//
+// Callers written before connections were leased must add the Release call:
+// an unreleased lease keeps a replaced *sql.DB open, and makes Dispose wait
+// for DriverSettings.DisposeTimeout and log a warning before closing it.
 func (c *Connector) GetConnectionFromQuery(ctx context.Context, q *Query) (string, CachedConnection, error) {
+	key, dbConn, _, err := c.getConnectionFromQuery(ctx, q)
+	return key, dbConn, err
+}
+
+// getConnectionFromQuery is GetConnectionFromQuery, also reporting whether
+// the connection was opened for q rather than found in the cache.
+func (c *Connector) getConnectionFromQuery(ctx context.Context, q *Query) (string, CachedConnection, bool, error) {
 	if !c.enableMultipleConnections && !c.driverSettings.ForwardHeaders && len(q.ConnectionArgs) > 0 && string(q.ConnectionArgs) != "{}" {
-		return "", CachedConnection{}, MissingMultipleConnectionsConfig
+		return "", CachedConnection{}, false, MissingMultipleConnectionsConfig
 	}
 	// The database connection may vary depending on query arguments
 	// The raw arguments are used as key to store the db connection in memory so they can be reused
 	key := c.defaultKey
-	dbConn, ok := c.getDBConnection(key)
-	if !ok {
-		return "", CachedConnection{}, MissingDBConnection
-	}
 	if !c.enableMultipleConnections || len(q.ConnectionArgs) == 0 {
 		backend.Logger.Debug("using single user connection")
-		return key, dbConn, nil
+		if endpointKey, endpoint := c.pickEndpoint(key, q); endpoint != 0 {
+			return c.leaseOrConnect(ctx, endpointKey, nil, endpoint)
+		}
+		if err := c.allowRequest(key); err != nil {
+			return "", CachedConnection{}, false, err
+		}
+		dbConn, ok := c.leaseDBConnection(key)
+		if !ok {
+			return "", CachedConnection{}, false, MissingDBConnection
+		}
+		c.measureEndpoint(ctx, key, dbConn)
+		return key, dbConn, false, nil
+	}
+
+	key, endpoint := c.pickEndpoint(keyWithConnectionArgs(c.UID, q.ConnectionArgs), q)
+	return c.leaseOrConnect(ctx, key, q.ConnectionArgs, endpoint)
+}
+
+// leaseOrConnect leases the connection cached under key, opening it to
+// endpoint with connectionArgs if needed.
+func (c *Connector) leaseOrConnect(ctx context.Context, key string, connectionArgs json.RawMessage, endpoint int) (string, CachedConnection, bool, error) {
+	dbConn, ok := c.getDBConnection(c.defaultKey)
+	if !ok {
+		return "", CachedConnection{}, false, MissingDBConnection
 	}
 
-	key = keyWithConnectionArgs(c.UID, q.ConnectionArgs)
-	if cachedConn, ok := c.getDBConnection(key); ok {
+	if err := c.allowRequest(key); err != nil {
+		return "", CachedConnection{}, false, err
+	}
+	if cachedConn, ok := c.leaseDBConnection(key); ok {
 		backend.Logger.Debug("cached connection")
-		return key, cachedConn, nil
+		c.measureEndpoint(ctx, key, cachedConn)
+		return key, cachedConn, false, nil
 	}
 
-	db, err := c.driver.Connect(ctx, dbConn.settings, q.ConnectionArgs)
+	// Concurrent queries with the same new ConnectionArgs share one connection
+	// rather than each opening one, of which all but the last stored would leak.
+	settings := dbConn.settings
+	conn, err := c.coalesce(ctx, &c.connecting, key, func(ctx context.Context) (CachedConnection, error) {
+		if cachedConn, ok := c.getDBConnection(key); ok {
+			return cachedConn, nil
+		}
+		db, err := c.open(ctx, settings, connectionArgs, endpoint)
+		if err != nil {
+			backend.Logger.Debug("connect error " + err.Error())
+			c.recordConnect(key, err)
+			return CachedConnection{}, backend.DownstreamError(err)
+		}
+		backend.Logger.Debug("new connection(multiple) created")
+		// Assign this connection in the cache
+		conn := c.newCachedConnection(db, settings, connectionArgs)
+		c.storeDBConnection(key, conn)
+		return conn, nil
+	})
+	if err != nil {
+		return "", CachedConnection{}, false, err
+	}
+	dbConn, err = c.leaseShared(conn, key)
 	if err != nil {
-		backend.Logger.Debug("connect error " + err.Error())
-		return "", CachedConnection{}, backend.DownstreamError(err)
+		return "", CachedConnection{}, false, err
 	}
-	backend.Logger.Debug("new connection(multiple) created")
-	// Assign this connection in the cache
-	dbConn = CachedConnection{db, dbConn.settings}
-	c.storeDBConnection(key, dbConn)
+	c.measureEndpoint(ctx, key, dbConn)
 
-	return key, dbConn, nil
+	return key, dbConn, true, nil
 }
 
 func shouldRetry(retryOn []string, err string) bool {
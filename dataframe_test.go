@@ -189,3 +189,78 @@ func TestNoRowsFrame(t *testing.T) {
 		})
 	}
 }
+
+// we test that every result set of a statement gets its own frame
+func TestMultipleResultSetFrames(t *testing.T) {
+	summary := test.Data{
+		Cols: []test.Column{
+			{Name: "region", DataType: "TEXT", Kind: ""},
+			{Name: "total", DataType: "INTEGER", Kind: int64(0)},
+		},
+		Rows: [][]any{{"eu", int64(3)}, {"us", int64(2)}},
+	}
+	detail := test.Data{
+		Cols: []test.Column{
+			{Name: "id", DataType: "INTEGER", Kind: int64(0)},
+			{Name: "region", DataType: "TEXT", Kind: ""},
+			{Name: "amount", DataType: "FLOAT", Kind: float64(0)},
+		},
+		Rows: [][]any{{int64(1), "eu", 1.5}, {int64(2), "us", 2.5}, {int64(3), "eu", 3.5}},
+	}
+	empty := test.Data{
+		Cols: []test.Column{{Name: "note", DataType: "TEXT", Kind: ""}},
+		Rows: [][]any{},
+	}
+
+	query := func(t *testing.T, id string, cfg string, sets ...test.Data) backend.DataResponse {
+		t.Helper()
+		driver, _ := test.NewDriver(id, summary, nil, test.DriverOpts{ResultSets: sets}, nil)
+		ds := sqlds.NewDatasource(driver)
+		ds.EnableRowLimit = true
+
+		settings := backend.DataSourceInstanceSettings{UID: id, JSONData: []byte(cfg)}
+		_, err := ds.NewDatasource(context.Background(), settings)
+		require.NoError(t, err)
+
+		r, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{DataSourceInstanceSettings: &settings},
+			Queries: []backend.DataQuery{
+				{RefID: "A", JSON: []byte(fmt.Sprintf(`{ "rawSql": "EXEC report", "format": %d }`, sqlutil.FormatOptionTable))},
+			},
+		})
+		require.NoError(t, err)
+		d := r.Responses["A"]
+		require.NoError(t, d.Error)
+		return d
+	}
+
+	t.Run("single result set keeps the RefID", func(t *testing.T) {
+		d := query(t, "result-sets-single", "{}")
+		require.Len(t, d.Frames, 1)
+		require.Equal(t, "A", d.Frames[0].Name)
+		require.Equal(t, 2, d.Frames[0].Rows())
+	})
+
+	t.Run("one frame per result set", func(t *testing.T) {
+		d := query(t, "result-sets-multiple", "{}", detail, empty)
+		require.Len(t, d.Frames, 3)
+		for i, want := range []struct {
+			name   string
+			fields int
+			rows   int
+		}{{"A-0", 2, 2}, {"A-1", 3, 3}, {"A-2", 1, 0}} {
+			require.Equal(t, want.name, d.Frames[i].Name)
+			require.Len(t, d.Frames[i].Fields, want.fields)
+			require.Equal(t, want.rows, d.Frames[i].Rows())
+			require.Equal(t, "EXEC report", d.Frames[i].Meta.ExecutedQueryString)
+		}
+	})
+
+	t.Run("row limit applies across result sets", func(t *testing.T) {
+		d := query(t, "result-sets-limit", `{ "rowLimit": 3 }`, detail)
+		require.Len(t, d.Frames, 2)
+		require.Equal(t, 2, d.Frames[0].Rows())
+		require.Equal(t, 1, d.Frames[1].Rows())
+		require.Len(t, d.Frames[1].Meta.Notices, 1)
+	})
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const defaultKeySuffix = "default"
@@ -56,6 +57,13 @@ func keyWithConnectionArgs(datasourceUID string, connArgs json.RawMessage) strin
 type CachedConnection struct {
 	db       *sql.DB
 	settings backend.DataSourceInstanceSettings
+	// connectionArgs are those the connection was opened with, so the
+	// keepalive worker can open it again.
+	connectionArgs json.RawMessage
+	// ref counts the leases on db when the Connector opened it, and is nil
+	// otherwise. lease is set on the copies the Connector hands out.
+	ref   *connRef
+	lease *connLease
 }
 
 // DB returns the underlying *sql.DB.
@@ -67,11 +75,16 @@ func (c CachedConnection) Settings() backend.DataSourceInstanceSettings { return
 
 // Close closes the underlying *sql.DB. It is safe to call multiple times;
 // subsequent calls return the same error database/sql would return. A zero
-// value or empty cache slot (nil db) is a no-op.
+// value or empty cache slot (nil db) is a no-op. While a connection opened by
+// the Connector is leased, see Release, Close only stops new leases and the
+// *sql.DB is closed when the last lease is released.
 func (c CachedConnection) Close() error {
 	if c.db == nil {
 		return nil
 	}
+	if c.ref != nil && !c.ref.retire() {
+		return nil
+	}
 	return c.db.Close()
 }
 
@@ -98,10 +111,13 @@ type SQLDatasource struct {
 	queryArgSetter     QueryArgSetter
 	queryErrorMutator  QueryErrorMutator
 	checkHealthMutator CheckHealthMutator
+	retryClassifier    RetryClassifier
 	// rowCapacityHint mirrors DriverSettings.RowCapacityHint, resolved once
 	// at init and passed into every DBQuery so FrameFromRows can presize
 	// its Fields. Zero disables presizing.
 	rowCapacityHint int64
+	// limiter enforces DriverSettings.MaxConcurrentQueries. Nil when unset.
+	limiter *queryLimiter
 	// PreCheckHealth (optional). Performs custom health check before the Connect method
 	PreCheckHealth func(ctx context.Context, req *backend.CheckHealthRequest) *backend.CheckHealthResult
 	// PostCheckHealth (optional).Performs custom health check after the Connect method
@@ -116,13 +132,70 @@ type SQLDatasource struct {
 	// zero-value SQLDatasource built without NewDatasource still interpolates.
 	Interpolator Interpolator
 
+	// EnableStreaming (optional). When true, queries can be run through the
+	// backend.StreamHandler methods: the frontend subscribes to
+	// ds/<uid>/query/<refID> with the query as subscription data, and rows are
+	// sent as frames of DriverSettings.StreamBatchSize rows instead of being
+	// buffered into a single frame.
+	EnableStreaming bool
+
+	// ResultCacheFactory (optional). When non-nil, NewDatasource invokes this
+	// factory once and serves repeated queries from the returned ResultCache.
+	// A nil factory resolves to NewLRUResultCache when
+	// DriverSettings.ResultCacheTTL is set, and to no result caching
+	// otherwise.
+	ResultCacheFactory func() ResultCache
+	resultCache        ResultCache
+
+	// EnableQueryDeduplication (optional). When true, identical queries (same
+	// interpolated SQL, connection, query args, time range and format) that
+	// run concurrently, within or across QueryData calls, share a single
+	// execution against the database. Each caller gets its own copy of the
+	// resulting frames, named after its own RefID. The shared execution is
+	// tracked for cancellation once, and canceled when every caller waiting
+	// for it has gone.
+	EnableQueryDeduplication bool
+	inflight                 inflightQueries
+
+	// EnableMultiStatement (optional). When true, a query's SQL is split into
+	// statements on top-level semicolons, and a query made of several
+	// statements runs them in order on one connection, so earlier statements
+	// can set session variables or create temporary tables used by later ones.
+	// Only the final statement's frames are returned unless
+	// MultiStatementAllResults is also set, in which case every statement
+	// returning rows contributes frames named <RefID>-<statement index>.
+	EnableMultiStatement     bool
+	MultiStatementAllResults bool
+
+	// EnableQueryCancellation (optional). When true, running queries are
+	// tracked and exposed through the /queries resource, and can be canceled
+	// through /queries/{id}/cancel. Canceling cancels the query's context and,
+	// if the driver implements Canceler, asks the driver to stop it on the
+	// database server. Users see and cancel only their own queries;
+	// organization admins see and cancel every query.
+	EnableQueryCancellation bool
+	queries                 queryRegistry
+	canceler                Canceler
+
+	// QueryAuditor (optional). Called once for every query executed against
+	// the database, after it completes, with who ran which SQL on which
+	// connection. NewDatasource installs the driver when it implements
+	// QueryAuditor.
+	QueryAuditor QueryAuditor
+
 	// ConnectionCacheFactory (optional). When non-nil, the Connector invokes
 	// this factory once during construction and uses the returned
 	// ConnectionCache for all per-ConnectionArgs *sql.DB storage. A nil
 	// factory resolves to NewSyncMapCache(), preserving the pre-extension
 	// behaviour byte-for-byte. Plugins use a factory to install a TTL or
-	// LRU cache; per-cache configuration is captured by closure.
+	// LRU cache, such as NewTTLCache or NewLRUCache; per-cache configuration
+	// is captured by closure.
 	ConnectionCacheFactory func() ConnectionCache
+
+	// MetricsProvider (optional). The Prometheus collectors, and the
+	// responseobs registry, the datasource records to. A nil provider resolves
+	// to the package-level collectors on the default Prometheus registry.
+	MetricsProvider *MetricsProvider
 }
 
 // NewDatasource creates a new `SQLDatasource`.
@@ -132,6 +205,9 @@ func (ds *SQLDatasource) NewDatasource(ctx context.Context, settings backend.Dat
 	if ds.ConnectionCacheFactory != nil {
 		opts = append(opts, WithCache(ds.ConnectionCacheFactory()))
 	}
+	if ds.MetricsProvider != nil {
+		opts = append(opts, WithMetricsProvider(ds.MetricsProvider))
+	}
 	conn, err := NewConnector(ctx, ds.driver(), settings, ds.EnableMultipleConnections, opts...)
 	if err != nil {
 		return nil, backend.DownstreamError(err)
@@ -149,11 +225,19 @@ func (ds *SQLDatasource) NewDatasource(ctx context.Context, settings backend.Dat
 		ds.CallResourceHandler = ds.ResourceMiddleware(ds.CallResourceHandler)
 	}
 
-	ds.metrics = NewMetrics(settings.Name, settings.Type, EndpointQuery)
+	ds.metrics = ds.MetricsProvider.NewMetrics(settings.Name, settings.Type, EndpointQuery)
+	ds.limiter = newQueryLimiter(conn.driverSettings.MaxConcurrentQueries, ds.metrics)
 
 	ds.rowLimit = ds.newRowLimit(ctx, conn)
 	ds.rowCapacityHint = conn.driverSettings.RowCapacityHint
 
+	switch {
+	case ds.ResultCacheFactory != nil:
+		ds.resultCache = ds.ResultCacheFactory()
+	case conn.driverSettings.ResultCacheTTL > 0:
+		ds.resultCache = NewLRUResultCache(conn.driverSettings.ResultCacheTTL, conn.driverSettings.ResultCacheMaxBytes)
+	}
+
 	return ds, nil
 }
 
@@ -169,6 +253,9 @@ func NewDatasource(c Driver) *SQLDatasource {
 	ds.queryArgSetter, _ = c.(QueryArgSetter)
 	ds.queryErrorMutator, _ = c.(QueryErrorMutator)
 	ds.checkHealthMutator, _ = c.(CheckHealthMutator)
+	ds.canceler, _ = c.(Canceler)
+	ds.retryClassifier, _ = c.(RetryClassifier)
+	ds.QueryAuditor, _ = c.(QueryAuditor)
 	ds.Interpolator = defaultInterpolator(ds)
 	return ds
 }
@@ -177,6 +264,9 @@ func NewDatasource(c Driver) *SQLDatasource {
 // Note: Called when testing and saving a datasource
 func (ds *SQLDatasource) Dispose() {
 	ds.connector.Dispose()
+	if ds.resultCache != nil {
+		ds.resultCache.Dispose()
+	}
 }
 
 // QueryData creates the Responses list and executes each query
@@ -191,7 +281,9 @@ func (ds *SQLDatasource) QueryData(ctx context.Context, req *backend.QueryDataRe
 	wg.Add(len(req.Queries))
 
 	if ds.queryDataMutator != nil {
-		ctx, req = ds.queryDataMutator.MutateQueryData(ctx, req)
+		mctx, span := startSpan(ctx, "QueryDataMutator")
+		mctx, req = ds.queryDataMutator.MutateQueryData(mctx, req)
+		ctx = endMutatorSpan(ctx, mctx, span)
 	}
 
 	// Execute each query and store the results by query RefID
@@ -224,13 +316,17 @@ func (ds *SQLDatasource) QueryData(ctx context.Context, req *backend.QueryDataRe
 				}
 			}()
 
+			ctx, span := startSpan(ctx, "query", attributeRefID.String(query.RefID))
 			frames, err := ds.handleQuery(ctx, query, headers)
 			if err == nil && ds.responseMutator != nil {
-				frames, err = ds.responseMutator.MutateResponse(ctx, frames)
+				mctx, mspan := startSpan(ctx, "ResponseMutator", attributeRefID.String(query.RefID))
+				frames, err = ds.responseMutator.MutateResponse(mctx, frames)
 				if err != nil {
 					err = backend.PluginError(err)
 				}
+				endSpan(mspan, err)
 			}
+			endSpan(span, err)
 
 			response.Set(query.RefID, backend.DataResponse{
 				Frames:      frames,
@@ -250,8 +346,46 @@ func (ds *SQLDatasource) QueryData(ctx context.Context, req *backend.QueryDataRe
 	return response.Response(), nil
 }
 
+// getConnection retrieves the connection q runs on, tracing the lookup and
+// recording the connection on the span of the query.
+func (ds *SQLDatasource) getConnection(ctx context.Context, q *Query) (string, CachedConnection, error) {
+	start := time.Now()
+	cctx, span := startSpan(ctx, "GetConnectionFromQuery", attributeRefID.String(q.RefID))
+	cacheKey, dbConn, created, err := ds.connector.getConnectionFromQuery(cctx, q)
+	result := "hit"
+	switch {
+	case err != nil:
+		result = "error"
+	case created:
+		result = "new"
+	}
+	ds.metrics.CollectConnectionAcquire(cctx, result, time.Since(start).Seconds())
+	if err == nil {
+		span.SetAttributes(connectionKeyAttribute(cacheKey))
+		trace.SpanFromContext(ctx).SetAttributes(connectionKeyAttribute(cacheKey))
+	}
+	endSpan(span, err)
+	return cacheKey, dbConn, err
+}
+
+// interpolateQuery applies the macros of q, recording the time it took.
+func (ds *SQLDatasource) interpolateQuery(ctx context.Context, q *Query, raw json.RawMessage) (string, error) {
+	start := time.Now()
+	ictx, span := startSpan(ctx, "interpolate", attributeRefID.String(q.RefID))
+	sql, err := ds.interpolate(ictx, q, raw)
+	status := StatusOK
+	if err != nil {
+		status = StatusError
+	}
+	ds.metrics.CollectInterpolation(ictx, status, time.Since(start).Seconds())
+	endSpan(span, err)
+	return sql, err
+}
+
 func (ds *SQLDatasource) GetDBFromQuery(ctx context.Context, q *Query) (*sql.DB, error) {
 	_, dbConn, err := ds.connector.GetConnectionFromQuery(ctx, q)
+	// The *sql.DB outlives the call, so it is not leased.
+	dbConn.Release()
 	return dbConn.db, err
 }
 
@@ -260,24 +394,26 @@ func (ds *SQLDatasource) handleQuery(ctx context.Context, req backend.DataQuery,
 	settings := ds.DriverSettings()
 
 	if ds.queryMutator != nil {
-		ctx, req = ds.queryMutator.MutateQuery(ctx, req)
+		mctx, span := startSpan(ctx, "QueryMutator", attributeRefID.String(req.RefID))
+		mctx, req = ds.queryMutator.MutateQuery(mctx, req)
+		ctx = endMutatorSpan(ctx, mctx, span)
 	}
 
 	// Convert the backend.DataQuery into a Query object
+	_, span := startSpan(ctx, "GetQuery", attributeRefID.String(req.RefID))
 	q, err := GetQuery(req, headers, settings.ForwardHeaders)
+	endSpan(span, err)
 	if err != nil {
 		return nil, err
 	}
+	trace.SpanFromContext(ctx).SetAttributes(formatAttribute(q.Format))
 
 	// Apply supported macros to the query. Uses ds.Interpolator if set,
 	// otherwise the package default — which preserves byte-for-byte parity
 	// with the legacy sqlutil.Interpolate path.
-	q.RawSQL, err = ds.interpolate(ctx, q, req.JSON)
+	q.RawSQL, err = ds.interpolateQuery(ctx, q, req.JSON)
 	if err != nil {
-		if errors.Is(err, sqlutil.ErrorBadArgumentCount) || errors.Is(err, ErrorParsingMacroBrackets) || err.Error() == ErrorParsingMacroBrackets.Error() {
-			err = backend.DownstreamError(err)
-		}
-		return sqlutil.ErrorFrameFromQuery(q), fmt.Errorf("%s: %w", "Could not apply macros", err)
+		return sqlutil.ErrorFrameFromQuery(q), interpolationError(err)
 	}
 
 	// Apply the default FillMode, overwritting it if the query specifies it
@@ -286,14 +422,19 @@ func (ds *SQLDatasource) handleQuery(ctx context.Context, req backend.DataQuery,
 		fillMode = q.FillMissing
 	}
 
+	// Apply the timeout and row limit the query asks for, within the
+	// configured maximums
+	limits := ds.resolveQueryLimits(settings, req.JSON)
+
 	// Retrieve the database connection
-	cacheKey, dbConn, err := ds.connector.GetConnectionFromQuery(ctx, q)
+	cacheKey, dbConn, err := ds.getConnection(ctx, q)
 	if err != nil {
 		return sqlutil.ErrorFrameFromQuery(q), err
 	}
+	defer dbConn.Release()
 
-	if settings.Timeout != 0 {
-		tctx, cancel := context.WithTimeout(ctx, settings.Timeout)
+	if limits.timeout != 0 {
+		tctx, cancel := context.WithTimeout(ctx, limits.timeout)
 		defer cancel()
 
 		ctx = tctx
@@ -304,49 +445,135 @@ func (ds *SQLDatasource) handleQuery(ctx context.Context, req backend.DataQuery,
 		args = ds.queryArgSetter.SetQueryArgs(ctx, headers)
 	}
 
+	run := func(ctx context.Context, dbConn CachedConnection) (data.Frames, error) {
+		ctx, done := ds.trackQuery(ctx, q, cacheKey, dbConn)
+		defer done()
+		release, err := ds.limiter.acquire(ctx)
+		if err != nil {
+			return sqlutil.ErrorFrameFromQuery(q), err
+		}
+		defer release()
+		defer ds.metrics.TrackInFlight()()
+		start := time.Now()
+		res, key, retries, err := ds.runQuery(ctx, q, dbConn, cacheKey, fillMode, limits.rowLimit, args)
+		ds.connector.recordQuery(key, err)
+		ds.auditQuery(ctx, q, key, AuditSourceDatabase, start, frameRows(res), retries, err)
+		return res, err
+	}
+	if ds.resultCache == nil && !ds.EnableQueryDeduplication {
+		return run(ctx, dbConn)
+	}
+
+	resultKey := resultCacheKey(q, cacheKey, fillMode, limits.rowLimit, args)
+	start := time.Now()
+	if ds.resultCache != nil {
+		if res, ok := ds.resultCache.Get(resultKey); ok {
+			ds.metrics.CollectResultCache(true)
+			ds.auditQuery(ctx, q, cacheKey, AuditSourceCache, start, frameRows(res), 0, nil)
+			return res, nil
+		}
+		ds.metrics.CollectResultCache(false)
+	}
+
+	var res data.Frames
+	if ds.EnableQueryDeduplication {
+		var joined bool
+		res, joined, err = ds.runDeduplicated(ctx, inflightKey(q, cacheKey, fillMode, limits.rowLimit, args), q.RefID, func(ctx context.Context) (data.Frames, error) {
+			// The shared run outlives the lease of the query that started
+			// it, so it takes its own.
+			shared, ok := ds.connector.lease(dbConn)
+			if !ok {
+				if shared, ok = ds.connector.leaseDBConnection(cacheKey); !ok {
+					return sqlutil.ErrorFrameFromQuery(q), backend.DownstreamError(ErrorConnectionClosed)
+				}
+			}
+			defer shared.Release()
+			return run(ctx, shared)
+		})
+		if joined {
+			ds.auditQuery(ctx, q, cacheKey, AuditSourceDedup, start, frameRows(res), 0, err)
+		}
+	} else {
+		res, err = run(ctx, dbConn)
+	}
+	if err == nil && res != nil && ds.resultCache != nil {
+		ds.resultCache.Set(resultKey, res)
+	}
+	return res, err
+}
+
+// runQuery runs q on dbConn, reconnecting and retrying as configured by the
+// driver settings when the query fails. It returns the cache key of the
+// connection q last ran on, which differs from cacheKey when a retry failed
+// over to another endpoint of a MultiEndpointDriver.
+func (ds *SQLDatasource) runQuery(ctx context.Context, q *Query, dbConn CachedConnection, cacheKey string, fillMode *data.FillMissing, rowLimit int64, args []interface{}) (data.Frames, string, int, error) {
+	settings := ds.DriverSettings()
 	queryErrorMutator := ds.queryErrorMutator
 
 	// FIXES:
 	//  * Some datasources (snowflake) expire connections or have an authentication token that expires if not used in 1 or 4 hours.
 	//    Because the datasource driver does not include an option for permanent connections, we retry the connection
 	//    if the query fails. NOTE: this does not include some errors like "ErrNoRows"
-	dbQuery := NewQuery(dbConn.db, dbConn.settings, ds.cachedConverters, fillMode, ds.rowLimit).
-		WithRowCapacityHint(ds.rowCapacityHint).
-		WithResponseThresholds(ds.DriverSettings().ResponseThresholds)
-	res, err := dbQuery.Run(ctx, q, queryErrorMutator, args...)
+	var statements []string
+	if ds.EnableMultiStatement {
+		statements = splitStatements(q.RawSQL, settings.BackslashEscapes)
+	}
+	retries := 0
+	exec := func(ctx context.Context, dbQuery *DBQuery) (data.Frames, error) {
+		if len(statements) > 1 {
+			return dbQuery.RunScript(ctx, q, statements, ds.MultiStatementAllResults, queryErrorMutator, args...)
+		}
+		return dbQuery.Run(ctx, q, queryErrorMutator, args...)
+	}
+
+	res, err := exec(ctx, ds.newDBQuery(dbConn.db, dbConn.settings, fillMode, rowLimit))
 	if err == nil {
-		return res, nil
+		return res, cacheKey, retries, nil
 	}
 
 	if errors.Is(err, ErrorNoResults) {
-		return res, nil
+		return res, cacheKey, retries, nil
 	}
 
+	// A retry after a connection error moves to the next healthy endpoint,
+	// so later retries continue from the connection it ran on.
+	current, key := dbConn, cacheKey
+
 	// If there's a query error that didn't exceed the
 	// context deadline retry the query
 	if errors.Is(err, ErrorQuery) && !errors.Is(err, context.DeadlineExceeded) {
-		// only retry on messages that contain specific errors
-		if shouldRetry(settings.RetryOn, err.Error()) {
+		// only retry errors the driver, or failing that RetryOn, classifies as retryable
+		decision := classifyRetry(ds.retryClassifier, settings.RetryOn, err)
+		if decision != RetryFail {
+			policy := settings.retryPolicy()
+			start := time.Now()
 			for i := 0; i < settings.Retries; i++ {
 				backend.Logger.Warn(fmt.Sprintf("query failed: %s. Retrying %d times", err.Error(), i))
-				db, err := ds.connector.Reconnect(ctx, dbConn, q, cacheKey)
-				if err != nil {
-					return nil, backend.DownstreamError(err)
+				if !waitRetry(ctx, policy, ds.metrics, RetryReasonError, i+1, start) {
+					break
 				}
-
-				if settings.Pause > 0 {
-					time.Sleep(time.Duration(settings.Pause * int(time.Second)))
+				retries++
+
+				rctx, span := startSpan(ctx, "retry", attributeRefID.String(q.RefID), attributeRetryAttempt.Int(i+1), connectionKeyAttribute(key))
+				if decision == RetryWithReconnect {
+					newKey, newConn, err := ds.connector.failover(rctx, current, q, key)
+					if err != nil {
+						endSpan(span, err)
+						return nil, key, retries, backend.DownstreamError(err)
+					}
+					defer newConn.Release()
+					current, key = newConn, newKey
+					ds.queries.setDB(ctx, current.db)
 				}
 
-				dbQuery := NewQuery(db, dbConn.settings, ds.cachedConverters, fillMode, ds.rowLimit).
-					WithRowCapacityHint(ds.rowCapacityHint).
-					WithResponseThresholds(ds.DriverSettings().ResponseThresholds)
-				res, err = dbQuery.Run(ctx, q, queryErrorMutator, args...)
+				res, err = exec(rctx, ds.newDBQuery(current.db, dbConn.settings, fillMode, rowLimit))
+				endSpan(span, err)
 				if err == nil {
-					return res, err
+					return res, key, retries, err
 				}
-				if !shouldRetry(settings.RetryOn, err.Error()) {
-					return res, err
+				decision = classifyRetry(ds.retryClassifier, settings.RetryOn, err)
+				if decision == RetryFail {
+					return res, key, retries, err
 				}
 				backend.Logger.Warn(fmt.Sprintf("Retry failed: %s", err.Error()))
 			}
@@ -354,32 +581,61 @@ func (ds *SQLDatasource) handleQuery(ctx context.Context, req backend.DataQuery,
 	}
 
 	// Check if the error is retryable and convert to downstream error if so
-	if errors.Is(err, ErrorQuery) && shouldRetry(settings.RetryOn, err.Error()) {
+	if errors.Is(err, ErrorQuery) && classifyRetry(ds.retryClassifier, settings.RetryOn, err) != RetryFail {
 		// Convert retryable errors to downstream errors
 		if !backend.IsDownstreamError(err) {
 			err = backend.DownstreamError(err)
 		}
 	}
 
-	// allow retries on timeouts
+	// allow retries on timeouts. A timeout does not tell the endpoint is
+	// down, so these reconnect to the same one.
 	if errors.Is(err, context.DeadlineExceeded) {
 		for i := 0; i < settings.Retries; i++ {
 			backend.Logger.Warn(fmt.Sprintf("connection timed out. retrying %d times", i))
-			db, err := ds.connector.Reconnect(ctx, dbConn, q, cacheKey)
+			ds.metrics.CollectRetry(ctx, RetryReasonTimeout, i+1)
+			retries++
+			rctx, span := startSpan(ctx, "retry", attributeRefID.String(q.RefID), attributeRetryAttempt.Int(i+1), connectionKeyAttribute(key))
+			newConn, err := ds.connector.reconnect(rctx, current, q, key)
 			if err != nil {
+				endSpan(span, err)
 				continue
 			}
+			defer newConn.Release()
+			current = newConn
+			ds.queries.setDB(ctx, current.db)
 
-			dbQuery := NewQuery(db, dbConn.settings, ds.cachedConverters, fillMode, ds.rowLimit).
-				WithRowCapacityHint(ds.rowCapacityHint)
-			res, err = dbQuery.Run(ctx, q, queryErrorMutator, args...)
+			res, err = exec(rctx, ds.newDBQuery(current.db, dbConn.settings, fillMode, rowLimit))
+			endSpan(span, err)
 			if err == nil {
-				return res, err
+				return res, key, retries, err
 			}
 		}
 	}
 
-	return res, err
+	return res, key, retries, err
+}
+
+// newDBQuery returns the DBQuery running a query on db, configured from the
+// datasource and its driver settings. Every attempt of a query, including
+// retries, is run through a DBQuery built here so they are observed alike.
+func (ds *SQLDatasource) newDBQuery(db *sql.DB, settings backend.DataSourceInstanceSettings, fillMode *data.FillMissing, rowLimit int64) *DBQuery {
+	driverSettings := ds.DriverSettings()
+	return NewQuery(db, settings, ds.cachedConverters, fillMode, rowLimit).
+		WithMetrics(ds.metrics).
+		WithRowCapacityHint(ds.rowCapacityHint).
+		WithResponseThresholds(driverSettings.ResponseThresholds).
+		WithExactResponseBytes(driverSettings.ExactResponseBytes).
+		WithBackslashEscapes(driverSettings.BackslashEscapes)
+}
+
+// interpolationError wraps an Interpolator failure, marking macro argument
+// errors as downstream since they come from the user's query text.
+func interpolationError(err error) error {
+	if errors.Is(err, sqlutil.ErrorBadArgumentCount) || errors.Is(err, ErrorParsingMacroBrackets) || err.Error() == ErrorParsingMacroBrackets.Error() {
+		err = backend.DownstreamError(err)
+	}
+	return fmt.Errorf("%s: %w", "Could not apply macros", err)
 }
 
 // CheckHealth pings the connected SQL database
@@ -82,10 +82,10 @@ func Test_getDBConnectionFromQuery(t *testing.T) {
 			settings := backend.DataSourceInstanceSettings{UID: tt.dsUID}
 			key := defaultKey(tt.dsUID)
 			// Add the mandatory default db
-			conn.storeDBConnection(key, CachedConnection{db, settings})
+			conn.storeDBConnection(key, CachedConnection{db: db, settings: settings})
 			if tt.existingDB != nil {
 				key = keyWithConnectionArgs(tt.dsUID, []byte(tt.args))
-				conn.storeDBConnection(key, CachedConnection{tt.existingDB, settings})
+				conn.storeDBConnection(key, CachedConnection{db: tt.existingDB, settings: settings})
 			}
 
 			key, dbConn, err := conn.GetConnectionFromQuery(context.Background(), &Query{ConnectionArgs: json.RawMessage(tt.args)})
@@ -296,3 +296,34 @@ func TestRowLimitFromEnvVar(t *testing.T) {
 		})
 	}
 }
+
+func TestRowLimitFromQuery(t *testing.T) {
+	testData := test.Data{
+		Cols: []test.Column{
+			{Name: "id", DataType: "INTEGER", Kind: int64(0)},
+		},
+		Rows: [][]any{{int64(1)}, {int64(2)}, {int64(3)}},
+	}
+
+	driver, _ := test.NewDriver("rowlimit-from-query", testData, nil, test.DriverOpts{}, nil)
+	ds := sqlds.NewDatasource(driver)
+	settings := backend.DataSourceInstanceSettings{UID: "rowlimit-from-query", JSONData: []byte(`{"maxRowLimit": 2, "queryLimitOverrides": true}`)}
+	_, err := ds.NewDatasource(context.Background(), settings)
+	require.NoError(t, err)
+
+	query := func(json string) int {
+		resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: backend.PluginContext{DataSourceInstanceSettings: &settings},
+			Queries:       []backend.DataQuery{{RefID: "A", JSON: []byte(json)}},
+		})
+		require.NoError(t, err)
+		require.NoError(t, resp.Responses["A"].Error)
+		require.Len(t, resp.Responses["A"].Frames, 1)
+		return resp.Responses["A"].Frames[0].Rows()
+	}
+
+	// The admin maximum applies to every query, and a query can only lower it.
+	assert.Equal(t, 2, query(`{"rawSql": "SELECT * FROM test", "format": 1}`))
+	assert.Equal(t, 1, query(`{"rawSql": "SELECT * FROM test", "format": 1, "rowLimit": 1}`))
+	assert.Equal(t, 2, query(`{"rawSql": "SELECT * FROM test", "format": 1, "rowLimit": 10}`))
+}
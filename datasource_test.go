@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
@@ -15,6 +16,7 @@ import (
 	"github.com/grafana/sqlds/v5/mock"
 	"github.com/grafana/sqlds/v5/test"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_health_retries(t *testing.T) {
@@ -381,3 +383,205 @@ func (h *panickingDBHandler) Columns() []string {
 func (h *panickingDBHandler) Next(dest []driver.Value) error {
 	return errors.New("no more rows")
 }
+
+func Test_query_result_cache(t *testing.T) {
+	cfg := `{ "timeout": 0, "retries": 0, "resultCacheTTL": 60000000000 }`
+	req, handler, ds := queryRequest(t, "result-cache", test.DriverOpts{}, cfg, nil)
+
+	for i := 0; i < 3; i++ {
+		data, err := ds.QueryData(context.Background(), req)
+		assert.Nil(t, err)
+		assert.Nil(t, data.Responses["foo"].Error)
+	}
+	assert.Equal(t, 1, handler.State.QueryAttempts)
+
+	// A different time range is a different result.
+	req.Queries[0].TimeRange.To = req.Queries[0].TimeRange.To.Add(time.Minute)
+	_, err := ds.QueryData(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, handler.State.QueryAttempts)
+}
+
+func Test_query_multi_statement(t *testing.T) {
+	data := test.Data{
+		Cols: []test.Column{{Name: "v", DataType: "INTEGER", Kind: int64(0)}},
+		Rows: [][]any{{int64(1)}},
+	}
+	script := `{ "rawSql": "SET @x = 1; SELECT 'a;b'; SELECT @x", "format": 1 }`
+
+	run := func(t *testing.T, name string, allResults bool) (backend.DataResponse, *test.SqlHandler) {
+		t.Helper()
+		driver, handler := test.NewDriver(name, data, nil, test.DriverOpts{}, nil)
+		ds := sqlds.NewDatasource(driver)
+		ds.EnableMultiStatement = true
+		ds.MultiStatementAllResults = allResults
+
+		req, settings := setupQueryRequest(name, "{}")
+		req.Queries[0].JSON = []byte(script)
+		_, err := ds.NewDatasource(context.Background(), settings)
+		require.NoError(t, err)
+
+		res, err := ds.QueryData(context.Background(), req)
+		require.NoError(t, err)
+		return res.Responses["foo"], handler
+	}
+
+	t.Run("returns the final statement", func(t *testing.T) {
+		res, handler := run(t, "multi-statement-final", false)
+		require.NoError(t, res.Error)
+		assert.Equal(t, 3, handler.State.QueryAttempts)
+		require.Len(t, res.Frames, 1)
+		assert.Equal(t, "foo", res.Frames[0].Name)
+		assert.Equal(t, "SELECT @x", res.Frames[0].Meta.ExecutedQueryString)
+	})
+
+	t.Run("returns every statement with rows", func(t *testing.T) {
+		res, handler := run(t, "multi-statement-all", true)
+		require.NoError(t, res.Error)
+		assert.Equal(t, 3, handler.State.QueryAttempts)
+		require.Len(t, res.Frames, 3)
+		for i, frame := range res.Frames {
+			assert.Equal(t, fmt.Sprintf("foo-%d", i), frame.Name)
+		}
+		assert.Equal(t, "SELECT 'a;b'", res.Frames[1].Meta.ExecutedQueryString)
+	})
+
+	t.Run("row limit applies to the whole script", func(t *testing.T) {
+		driver, _ := test.NewDriver("multi-statement-rowlimit", data, nil, test.DriverOpts{}, nil)
+		ds := sqlds.NewDatasource(driver)
+		ds.EnableMultiStatement = true
+		ds.MultiStatementAllResults = true
+		ds.SetDefaultRowLimit(2)
+		req, settings := setupQueryRequest("multi-statement-rowlimit", "{}")
+		req.Queries[0].JSON = []byte(script)
+		_, err := ds.NewDatasource(context.Background(), settings)
+		require.NoError(t, err)
+
+		res, err := ds.QueryData(context.Background(), req)
+		require.NoError(t, err)
+		frames := res.Responses["foo"].Frames
+		require.Len(t, frames, 3)
+		for i, want := range []int{1, 1, 0} {
+			assert.Equal(t, want, frames[i].Rows(), "frame %d", i)
+		}
+		require.Len(t, frames[1].Meta.Notices, 1)
+		assert.Contains(t, frames[1].Meta.Notices[0].Text, "limited to 2")
+	})
+
+	t.Run("backslash escaped quotes", func(t *testing.T) {
+		driver, handler := test.NewDriver("multi-statement-backslash", data, nil, test.DriverOpts{}, nil)
+		ds := sqlds.NewDatasource(driver)
+		ds.EnableMultiStatement = true
+		req, settings := setupQueryRequest("multi-statement-backslash", `{ "backslashEscapes": true }`)
+		req.Queries[0].JSON = []byte(`{ "rawSql": "SELECT 'a\\';b'; SELECT 2", "format": 1 }`)
+		_, err := ds.NewDatasource(context.Background(), settings)
+		require.NoError(t, err)
+
+		res, err := ds.QueryData(context.Background(), req)
+		require.NoError(t, err)
+		require.NoError(t, res.Responses["foo"].Error)
+		assert.Equal(t, 2, handler.State.QueryAttempts)
+		assert.Equal(t, "SELECT 2", res.Responses["foo"].Frames[0].Meta.ExecutedQueryString)
+	})
+
+	t.Run("disabled sends the script as one query", func(t *testing.T) {
+		driver, handler := test.NewDriver("multi-statement-disabled", data, nil, test.DriverOpts{}, nil)
+		ds := sqlds.NewDatasource(driver)
+		req, settings := setupQueryRequest("multi-statement-disabled", "{}")
+		req.Queries[0].JSON = []byte(script)
+		_, err := ds.NewDatasource(context.Background(), settings)
+		require.NoError(t, err)
+
+		res, err := ds.QueryData(context.Background(), req)
+		require.NoError(t, err)
+		require.NoError(t, res.Responses["foo"].Error)
+		assert.Equal(t, 1, handler.State.QueryAttempts)
+	})
+}
+
+type transientError struct{}
+
+func (transientError) Error() string { return "transient" }
+
+type classifyingDriver struct {
+	test.TestDS
+	decision sqlds.RetryDecision
+}
+
+func (d classifyingDriver) ClassifyRetry(err error) sqlds.RetryDecision {
+	var transient transientError
+	if errors.As(err, &transient) {
+		return d.decision
+	}
+	return sqlds.RetryDefault
+}
+
+func Test_query_retry_classifier(t *testing.T) {
+	tests := []struct {
+		name       string
+		decision   sqlds.RetryDecision
+		retryOn    string
+		attempts   int
+		reconnects int
+		downstream bool
+	}{
+		{name: "retry with reconnect", decision: sqlds.RetryWithReconnect, attempts: 4, reconnects: 3, downstream: true},
+		{name: "retry without reconnect", decision: sqlds.RetryWithoutReconnect, attempts: 4, reconnects: 0, downstream: true},
+		{name: "fail overrides RetryOn", decision: sqlds.RetryFail, retryOn: "transient", attempts: 1, reconnects: 0},
+		{name: "default falls back to RetryOn", decision: sqlds.RetryDefault, retryOn: "transient", attempts: 4, reconnects: 3, downstream: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			connects := 0
+			opts := test.DriverOpts{
+				QueryError: transientError{},
+				OnConnect:  func([]byte) { connects++ },
+			}
+			name := "retry-classifier-" + tt.name
+			driver, handler := test.NewDriver(name, test.Data{}, nil, opts, nil)
+			ds := sqlds.NewDatasource(classifyingDriver{TestDS: driver, decision: tt.decision})
+
+			req, settings := setupQueryRequest(name, fmt.Sprintf(`{ "retries": 3, "retryOn": [%q] }`, tt.retryOn))
+			_, err := ds.NewDatasource(context.Background(), settings)
+			require.NoError(t, err)
+			connects = 0
+
+			data, err := ds.QueryData(context.Background(), req)
+			require.NoError(t, err)
+			res := data.Responses["foo"]
+			assert.Error(t, res.Error)
+			assert.Equal(t, tt.attempts, handler.State.QueryAttempts)
+			assert.Equal(t, tt.reconnects, connects)
+			if tt.downstream {
+				assert.Equal(t, backend.ErrorSourceDownstream, res.ErrorSource)
+			}
+		})
+	}
+}
+
+func Test_query_circuit_breaker(t *testing.T) {
+	opts := test.DriverOpts{
+		QueryError: transientError{},
+	}
+	name := "circuit-breaker"
+	driver, handler := test.NewDriver(name, test.Data{}, nil, opts, nil)
+	ds := sqlds.NewDatasource(driver)
+
+	req, settings := setupQueryRequest(name, `{ "retryOn": ["transient"], "circuitBreakerFailures": 2 }`)
+	_, err := ds.NewDatasource(context.Background(), settings)
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		data, err := ds.QueryData(context.Background(), req)
+		require.NoError(t, err)
+		assert.False(t, errors.Is(data.Responses["foo"].Error, sqlds.ErrorCircuitOpen))
+	}
+	assert.Equal(t, 2, handler.State.QueryAttempts)
+
+	data, err := ds.QueryData(context.Background(), req)
+	require.NoError(t, err)
+	res := data.Responses["foo"]
+	assert.ErrorIs(t, res.Error, sqlds.ErrorCircuitOpen)
+	assert.Equal(t, backend.ErrorSourceDownstream, res.ErrorSource)
+	assert.Equal(t, 2, handler.State.QueryAttempts)
+}
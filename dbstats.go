@@ -0,0 +1,136 @@
+package sqlds
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dbStatsLabels identify the pool a sample comes from. The connection key
+// is hashed, see connectionKeyHash.
+var dbStatsLabels = []string{"datasource_uid", "connection_key"}
+
+// dbStatsCollector exports the database/sql pool statistics of every
+// connection cached by the Connectors added to it. The statistics are read
+// at scrape time by walking each Connector's ConnectionCache, so connections
+// opened or evicted between scrapes need no bookkeeping.
+type dbStatsCollector struct {
+	mu sync.RWMutex
+	// connectors is in the order they were added. A datasource instance
+	// being replaced has its old Connector live until it is disposed, so
+	// when two Connectors cache the same key the newest one is exported.
+	connectors []*Connector
+
+	maxOpen           *prometheus.Desc
+	open              *prometheus.Desc
+	inUse             *prometheus.Desc
+	idle              *prometheus.Desc
+	waitCount         *prometheus.Desc
+	waitDuration      *prometheus.Desc
+	maxIdleClosed     *prometheus.Desc
+	maxIdleTimeClosed *prometheus.Desc
+	maxLifetimeClosed *prometheus.Desc
+
+	cacheSize      *prometheus.Desc
+	cacheEvictions *prometheus.Desc
+}
+
+// newDBStatsCollector returns a dbStatsCollector registered on reg. Like
+// promauto, it panics if reg already holds one.
+func newDBStatsCollector(reg prometheus.Registerer) *dbStatsCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("plugins", "sql_pool", name), help, dbStatsLabels, nil)
+	}
+	c := &dbStatsCollector{
+		maxOpen:           desc("max_open_connections", "Maximum number of open connections to the database of a SQL datasource connection pool"),
+		open:              desc("open_connections", "Number of established connections, in use or idle, of a SQL datasource connection pool"),
+		inUse:             desc("in_use_connections", "Number of connections in use of a SQL datasource connection pool"),
+		idle:              desc("idle_connections", "Number of idle connections of a SQL datasource connection pool"),
+		waitCount:         desc("wait_count_total", "Number of times a SQL datasource query waited for a connection from the pool"),
+		waitDuration:      desc("wait_duration_seconds_total", "Total time SQL datasource queries waited for a connection from the pool"),
+		maxIdleClosed:     desc("max_idle_closed_total", "Number of connections of a SQL datasource pool closed due to the maximum of idle connections"),
+		maxIdleTimeClosed: desc("max_idle_time_closed_total", "Number of connections of a SQL datasource pool closed due to the maximum idle time"),
+		maxLifetimeClosed: desc("max_lifetime_closed_total", "Number of connections of a SQL datasource pool closed due to the maximum lifetime"),
+		cacheSize: prometheus.NewDesc("plugins_sql_connection_cache_entries",
+			"Number of connections cached by a SQL datasource", []string{"datasource_uid"}, nil),
+		cacheEvictions: prometheus.NewDesc("plugins_sql_connection_cache_evictions_total",
+			"Number of connections a SQL datasource evicted from its connection cache", []string{"datasource_uid"}, nil),
+	}
+	reg.MustRegister(c)
+	return c
+}
+
+// add makes the collector export the pools cached by c until remove is
+// called.
+func (s *dbStatsCollector) add(c *Connector) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connectors = append(s.connectors, c)
+}
+
+func (s *dbStatsCollector) remove(c *Connector) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connectors = slices.DeleteFunc(s.connectors, func(other *Connector) bool { return other == c })
+}
+
+// Describe implements prometheus.Collector.
+func (s *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.maxOpen
+	ch <- s.open
+	ch <- s.inUse
+	ch <- s.idle
+	ch <- s.waitCount
+	ch <- s.waitDuration
+	ch <- s.maxIdleClosed
+	ch <- s.maxIdleTimeClosed
+	ch <- s.maxLifetimeClosed
+	ch <- s.cacheSize
+	ch <- s.cacheEvictions
+}
+
+// Collect implements prometheus.Collector.
+func (s *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	s.mu.RLock()
+	connectors := slices.Clone(s.connectors)
+	s.mu.RUnlock()
+
+	type pool struct{ uid, key string }
+	seen := map[pool]bool{}
+	seenCaches := map[string]bool{}
+	for _, c := range slices.Backward(connectors) {
+		// Caches that count their evictions, like EvictingCache, export
+		// their size and evictions too.
+		if cache, ok := c.connCache().(interface{ Stats() CacheStats }); ok && !seenCaches[c.UID] {
+			seenCaches[c.UID] = true
+			stats := cache.Stats()
+			ch <- prometheus.MustNewConstMetric(s.cacheSize, prometheus.GaugeValue, float64(stats.Size), c.UID)
+			ch <- prometheus.MustNewConstMetric(s.cacheEvictions, prometheus.CounterValue, float64(stats.Evictions), c.UID)
+		}
+		c.connCache().Range(func(key string, v CachedConnection) bool {
+			if v.db == nil || seen[pool{c.UID, key}] {
+				return true
+			}
+			seen[pool{c.UID, key}] = true
+			stats := v.db.Stats()
+			labels := []string{c.UID, connectionKeyHash(key)}
+			gauge := func(desc *prometheus.Desc, v float64) {
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, labels...)
+			}
+			counter := func(desc *prometheus.Desc, v float64) {
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, v, labels...)
+			}
+			gauge(s.maxOpen, float64(stats.MaxOpenConnections))
+			gauge(s.open, float64(stats.OpenConnections))
+			gauge(s.inUse, float64(stats.InUse))
+			gauge(s.idle, float64(stats.Idle))
+			counter(s.waitCount, float64(stats.WaitCount))
+			counter(s.waitDuration, stats.WaitDuration.Seconds())
+			counter(s.maxIdleClosed, float64(stats.MaxIdleClosed))
+			counter(s.maxIdleTimeClosed, float64(stats.MaxIdleTimeClosed))
+			counter(s.maxLifetimeClosed, float64(stats.MaxLifetimeClosed))
+			return true
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package sqlds_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/grafana/sqlds/v5"
+	"github.com/grafana/sqlds/v5/test"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBStatsCollector(t *testing.T) {
+	name := "dbstats-collector"
+	driver, _ := test.NewDriver(name, test.Data{}, nil, test.DriverOpts{}, nil)
+	reg := prometheus.NewRegistry()
+	_, settings := setupQueryRequest(name, "{}")
+	conn, err := sqlds.NewConnector(context.Background(), driver, settings, true, sqlds.WithMetricsProvider(sqlds.NewMetricsProvider(reg)))
+	require.NoError(t, err)
+
+	_, dbConn, err := conn.GetConnectionFromQuery(context.Background(), &sqlds.Query{ConnectionArgs: json.RawMessage(`{"db":"other"}`)})
+	require.NoError(t, err)
+	dbConn.Release()
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	open := findFamily(families, "plugins_sql_pool_open_connections")
+	require.NotNil(t, open)
+	require.Len(t, open.GetMetric(), 2, "expected a series for the default and the per-args connection")
+	keys := map[string]bool{}
+	for _, m := range open.GetMetric() {
+		for _, l := range m.GetLabel() {
+			switch l.GetName() {
+			case "datasource_uid":
+				assert.Equal(t, name, l.GetValue())
+			case "connection_key":
+				assert.Len(t, l.GetValue(), 16, "expected the hashed cache key")
+				keys[l.GetValue()] = true
+			}
+		}
+	}
+	assert.Len(t, keys, 2)
+	assert.NotNil(t, findFamily(families, "plugins_sql_pool_wait_duration_seconds_total"))
+
+	conn.Dispose()
+	families, err = reg.Gather()
+	require.NoError(t, err)
+	assert.Nil(t, findFamily(families, "plugins_sql_pool_open_connections"), "expected no series after Dispose")
+}
+
+func TestDBStatsCollector_CacheStats(t *testing.T) {
+	name := "dbstats-cache-stats"
+	driver, _ := test.NewDriver(name, test.Data{}, nil, test.DriverOpts{}, nil)
+	reg := prometheus.NewRegistry()
+	_, settings := setupQueryRequest(name, "{}")
+	conn, err := sqlds.NewConnector(context.Background(), driver, settings, true,
+		sqlds.WithCache(sqlds.NewLRUCache(1, 0)), sqlds.WithMetricsProvider(sqlds.NewMetricsProvider(reg)))
+	require.NoError(t, err)
+	defer conn.Dispose()
+
+	for _, args := range []string{`{"db":"a"}`, `{"db":"b"}`} {
+		_, dbConn, err := conn.GetConnectionFromQuery(context.Background(), &sqlds.Query{ConnectionArgs: json.RawMessage(args)})
+		require.NoError(t, err)
+		dbConn.Release()
+	}
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	size := findFamily(families, "plugins_sql_connection_cache_entries")
+	require.NotNil(t, size)
+	assert.Equal(t, float64(2), size.GetMetric()[0].GetGauge().GetValue())
+	evictions := findFamily(families, "plugins_sql_connection_cache_evictions_total")
+	require.NotNil(t, evictions)
+	assert.Equal(t, float64(1), evictions.GetMetric()[0].GetCounter().GetValue())
+}
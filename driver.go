@@ -32,10 +32,95 @@ type DriverSettings struct {
 	RowCapacityHint int64
 	// ResponseThresholds configures when a query response is considered
 	// "large" enough to emit a structured warn log. A zero value on
-	// either field disables that dimension. At the sqlds layer bytes
-	// cannot be measured cheaply, so only the Rows threshold takes
-	// effect for sqlds-emitted observations.
+	// any field disables that dimension. Response bytes are estimated
+	// from the field types and lengths of the frames unless
+	// ExactResponseBytes is set.
 	ResponseThresholds responseobs.Thresholds
+	// ExactResponseBytes measures response bytes by marshaling every frame
+	// to Arrow instead of estimating them. Exact, but costs an extra
+	// encoding of each response.
+	ExactResponseBytes bool
+	// StreamBatchSize is the number of rows sent per frame when a query is
+	// run through SQLDatasource.RunStream. Zero uses a default of 10,000.
+	StreamBatchSize int64
+	// ResultCacheTTL enables the default in-memory result cache when no
+	// SQLDatasource.ResultCacheFactory is set. Identical queries within the
+	// TTL are answered from the cache instead of the database. Zero (the
+	// default) disables result caching.
+	ResultCacheTTL time.Duration
+	// ResultCacheMaxBytes bounds the default result cache by the serialized
+	// size of the cached frames. Zero uses a default of 64 MiB.
+	ResultCacheMaxBytes int64
+	// MaxConcurrentQueries bounds how many queries a datasource instance runs
+	// against the database at once, across all requests. Queries over the
+	// limit wait for a free slot, and fail if their timeout passes while
+	// waiting. Zero (the default) disables the limit.
+	MaxConcurrentQueries int64
+	// QueryLimitOverrides lets a query request its own timeout and row limit
+	// through the "timeout" and "rowLimit" fields of its JSON, within
+	// MaxTimeout and MaxRowLimit. A malformed value is logged and ignored.
+	// False (the default) ignores those fields.
+	QueryLimitOverrides bool
+	// MaxTimeout caps Timeout, and the timeout a query can request when
+	// QueryLimitOverrides is set. Zero makes Timeout the cap, so queries can
+	// only shorten it. A zero Timeout stays unbounded.
+	MaxTimeout time.Duration
+	// MaxRowLimit caps the row limit of every query, including the one a
+	// query requests when QueryLimitOverrides is set, which can only lower
+	// the limit. Zero leaves the datasource row limit as the cap.
+	MaxRowLimit int64
+	// RetryPolicy paces the Retries of failed queries and connection
+	// attempts. When nil, retries back off exponentially with jitter, starting
+	// at Pause seconds. Drivers set it in code; it is never read from JSON.
+	RetryPolicy RetryPolicy `json:"-"`
+	// CircuitBreakerFailures enables a circuit breaker per connection: after
+	// this many consecutive connection failures, timeouts or retryable query
+	// errors, requests on the connection fail immediately with
+	// ErrorCircuitOpen until CircuitBreakerOpenDuration has passed. A single
+	// probe request then decides whether the breaker closes again. Zero (the
+	// default) disables circuit breaking.
+	CircuitBreakerFailures int
+	// CircuitBreakerOpenDuration is how long a tripped circuit breaker
+	// rejects requests before letting a probe through. Zero uses a default of
+	// 30 seconds.
+	CircuitBreakerOpenDuration time.Duration
+	// Pool tunes the connection pool of every *sql.DB the Connector stores.
+	// The maxOpenConns, maxIdleConns, connMaxLifetime and connMaxIdleTime
+	// fields of the datasource's jsonData override it, and fields left zero
+	// by both use the defaults of PoolSettings unless Pool.NoDefaults is set.
+	// See LoadPoolSettings.
+	Pool PoolSettings `json:"-"`
+	// DisposeTimeout is how long disposing a datasource waits for the queries
+	// running on its connections to finish before closing them anyway. Zero
+	// uses a default of 30 seconds.
+	DisposeTimeout time.Duration
+	// KeepaliveInterval enables a background worker pinging every cached
+	// connection on this interval and replacing those that fail, so idle
+	// sessions expired by the database are noticed before a user query runs
+	// on them. Each ping is bounded by Timeout, or by the interval when
+	// Timeout is zero. Drivers implementing CredentialRefresher also get their
+	// credentials refreshed ahead of expiry. Zero (the default) disables it.
+	KeepaliveInterval time.Duration
+	// EndpointRouting selects the endpoint each query runs on for drivers
+	// implementing MultiEndpointDriver. Empty uses EndpointRoundRobin.
+	EndpointRouting EndpointRouting
+	// EndpointCooldown is how long an endpoint of a MultiEndpointDriver that
+	// failed a ping, or a query with a connection error, receives no queries.
+	// Zero uses a default of 30 seconds.
+	EndpointCooldown time.Duration
+	// BackslashEscapes makes a backslash escape the next character inside
+	// quoted strings when SQLDatasource.EnableMultiStatement splits a query
+	// into statements, and when RedactSQL redacts audited SQL, for engines
+	// such as MySQL that treat 'a\';b' as a single string. False (the
+	// default) follows standard SQL, where only a doubled quote escapes a
+	// quote.
+	BackslashEscapes bool
+	// DoubleQuotedStrings makes RedactSQL treat double-quoted text in
+	// audited SQL as a string literal rather than a quoted identifier, for
+	// engines such as MySQL that accept "abc" as a string. False (the
+	// default) follows standard SQL, where double quotes delimit
+	// identifiers.
+	DoubleQuotedStrings bool
 }
 
 // Driver is a simple interface that defines how to connect to a backend SQL datasource
@@ -0,0 +1,362 @@
+package sqlds
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// MultiEndpointDriver is an additional interface that could be implemented by driver.
+// This adds the ability to the driver to connect to several endpoints serving
+// the same data, such as the primary and the read replicas of a cluster, so
+// queries keep working while one of them is down. Read queries, as told by
+// ReplicaRouter, are spread across the endpoints as
+// DriverSettings.EndpointRouting selects, and fail over to the next healthy
+// endpoint when retried after a connection error. Other queries always run
+// on endpoint 0, the one Driver.Connect connects to. An endpoint failing a
+// ping, or a query with a connection error, is skipped for
+// DriverSettings.EndpointCooldown.
+type MultiEndpointDriver interface {
+	// Endpoints returns the number of endpoints of the datasource.
+	Endpoints(settings backend.DataSourceInstanceSettings) int
+	// ConnectEndpoint connects to the endpoint at index endpoint, as Connect
+	// does to endpoint 0.
+	ConnectEndpoint(ctx context.Context, settings backend.DataSourceInstanceSettings, connectionArgs json.RawMessage, endpoint int) (*sql.DB, error)
+}
+
+// ReplicaRouter is an additional interface that could be implemented by driver.
+// This adds the ability to the driver of a MultiEndpointDriver to decide
+// which queries may run on an endpoint other than endpoint 0, such as a read
+// replica. Without it, only queries made of a single SELECT statement, or a
+// WITH query, that neither writes nor locks rows are spread across the
+// endpoints.
+type ReplicaRouter interface {
+	UseReplica(q *Query) bool
+}
+
+// EndpointRouting selects the endpoint of a MultiEndpointDriver each query
+// runs on.
+type EndpointRouting string
+
+const (
+	// EndpointRoundRobin sends queries to the healthy endpoints in turn.
+	EndpointRoundRobin EndpointRouting = "round-robin"
+	// EndpointLowestLatency sends queries to the healthy endpoint with the
+	// lowest ping latency. Endpoints are pinged when first connected to, and
+	// by the keepalive worker when DriverSettings.KeepaliveInterval is set.
+	EndpointLowestLatency EndpointRouting = "lowest-latency"
+)
+
+// defaultEndpointCooldown is how long an unhealthy endpoint is skipped when
+// DriverSettings.EndpointCooldown is not set.
+const defaultEndpointCooldown = 30 * time.Second
+
+// endpointSet tracks the health and latency of the endpoints of a
+// MultiEndpointDriver.
+type endpointSet struct {
+	routing  EndpointRouting
+	cooldown time.Duration
+
+	mu             sync.Mutex
+	next           int
+	unhealthyUntil []time.Time
+	// latency is a moving average of the ping latency of each endpoint,
+	// zero until it has been measured.
+	latency []time.Duration
+}
+
+func newEndpointSet(n int, routing EndpointRouting, cooldown time.Duration) *endpointSet {
+	switch routing {
+	case EndpointRoundRobin, EndpointLowestLatency:
+	case "":
+		routing = EndpointRoundRobin
+	default:
+		backend.Logger.Warn(fmt.Sprintf("unknown endpoint routing %q, using %q", routing, EndpointRoundRobin))
+		routing = EndpointRoundRobin
+	}
+	if cooldown <= 0 {
+		cooldown = defaultEndpointCooldown
+	}
+	return &endpointSet{
+		routing:        routing,
+		cooldown:       cooldown,
+		unhealthyUntil: make([]time.Time, n),
+		latency:        make([]time.Duration, n),
+	}
+}
+
+// pick returns the endpoint the next query runs on. When every endpoint is
+// unhealthy, the one whose cooldown ends first is tried rather than failing.
+func (s *endpointSet) pick() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	n := len(s.unhealthyUntil)
+
+	picked := -1
+	for i := range n {
+		endpoint := (s.next + i) % n
+		if now.Before(s.unhealthyUntil[endpoint]) {
+			continue
+		}
+		if s.routing == EndpointRoundRobin {
+			picked = endpoint
+			break
+		}
+		// Unmeasured endpoints go first, so each gets measured.
+		if picked == -1 || s.latency[endpoint] < s.latency[picked] {
+			picked = endpoint
+		}
+	}
+	if picked == -1 {
+		picked = 0
+		for endpoint := range n {
+			if s.unhealthyUntil[endpoint].Before(s.unhealthyUntil[picked]) {
+				picked = endpoint
+			}
+		}
+	}
+	if s.routing == EndpointRoundRobin {
+		s.next = picked + 1
+	}
+	return picked
+}
+
+// markUnhealthy skips endpoint for the cooldown.
+func (s *endpointSet) markUnhealthy(endpoint int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unhealthyUntil[endpoint] = time.Now().Add(s.cooldown)
+}
+
+// measured reports whether the latency of endpoint has been measured.
+func (s *endpointSet) measured(endpoint int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latency[endpoint] != 0
+}
+
+// observeLatency adds a ping latency of endpoint to its moving average.
+func (s *endpointSet) observeLatency(endpoint int, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.latency[endpoint] == 0 {
+		s.latency[endpoint] = max(d, 1)
+		return
+	}
+	s.latency[endpoint] = max((4*s.latency[endpoint]+d)/5, 1)
+}
+
+// endpointKeySuffix precedes the endpoint in the cache key of a connection to
+// another endpoint than the first. The keys of connections to the first
+// endpoint end in "-default" or a hash, so they never contain it.
+const endpointKeySuffix = "-endpoint"
+
+// newEndpointSet returns the endpointSet of driver when it is a
+// MultiEndpointDriver with more than one endpoint, and nil otherwise.
+func (c *Connector) newEndpointSet(settings backend.DataSourceInstanceSettings) *endpointSet {
+	multi, ok := c.driver.(MultiEndpointDriver)
+	if !ok {
+		return nil
+	}
+	n := multi.Endpoints(settings)
+	if n <= 1 {
+		return nil
+	}
+	return newEndpointSet(n, c.driverSettings.EndpointRouting, c.driverSettings.EndpointCooldown)
+}
+
+// pickEndpoint returns the cache key of the connection to the endpoint q
+// runs on, for the connection cached under key, and that endpoint. Queries
+// that are not routable run on endpoint 0.
+func (c *Connector) pickEndpoint(key string, q *Query) (string, int) {
+	if c.endpoints == nil || !c.routable(q) {
+		return key, 0
+	}
+	endpoint := c.endpoints.pick()
+	return endpointKey(key, endpoint), endpoint
+}
+
+// endpointKey returns the cache key of the connection to endpoint for the
+// connection cached under key.
+func endpointKey(key string, endpoint int) string {
+	if endpoint == 0 {
+		return key
+	}
+	return fmt.Sprintf("%s%s%d", key, endpointKeySuffix, endpoint)
+}
+
+// endpointOf returns the endpoint of the connection cached under key, read
+// from the suffix pickEndpoint gave the key.
+func (c *Connector) endpointOf(key string) int {
+	_, endpoint := c.splitEndpointKey(key)
+	return endpoint
+}
+
+// splitEndpointKey returns the key of the connection to endpoint 0 that key
+// was derived from by pickEndpoint, and the endpoint of key.
+func (c *Connector) splitEndpointKey(key string) (string, int) {
+	if c.endpoints == nil {
+		return key, 0
+	}
+	i := strings.LastIndex(key, endpointKeySuffix)
+	if i < 0 {
+		return key, 0
+	}
+	endpoint, err := strconv.Atoi(key[i+len(endpointKeySuffix):])
+	if err != nil || endpoint <= 0 || endpoint >= len(c.endpoints.latency) {
+		return key, 0
+	}
+	return key[:i], endpoint
+}
+
+// routable reports whether q may run on an endpoint other than endpoint 0:
+// as the driver's ReplicaRouter decides, or else when it is a read query.
+func (c *Connector) routable(q *Query) bool {
+	if router, ok := c.driver.(ReplicaRouter); ok {
+		return router.UseReplica(q)
+	}
+	return isReadQuery(q.RawSQL, c.driverSettings.BackslashEscapes)
+}
+
+// writeKeywords are the keywords that make a SELECT or WITH query write or
+// lock rows, as in SELECT ... INTO, SELECT ... FOR UPDATE or a WITH query
+// whose statement is an INSERT.
+var writeKeywords = map[string]bool{
+	"insert": true,
+	"update": true,
+	"delete": true,
+	"merge":  true,
+	"into":   true,
+	"share":  true,
+}
+
+// isReadQuery reports whether sql, as lexSQL tokenizes it, is a single SELECT
+// or WITH statement without any of writeKeywords. A trailing semicolon is
+// allowed; further statements after it are not.
+func isReadQuery(sql string, backslashEscapes bool) bool {
+	var first string
+	ended := false
+	for _, token := range lexSQL(sql, backslashEscapes) {
+		if token.kind == sqlSpace || token.kind == sqlComment {
+			continue
+		}
+		if ended {
+			return false
+		}
+		switch {
+		case token.kind == sqlPunct && token.text == ";":
+			ended = true
+		case token.kind != sqlWord:
+		case first == "":
+			first = strings.ToLower(token.text)
+			if first != "select" && first != "with" {
+				return false
+			}
+		case writeKeywords[strings.ToLower(token.text)]:
+			return false
+		}
+	}
+	return first != ""
+}
+
+// failover replaces dbConn, cached under cacheKey, after q failed on it with
+// a connection error, returning the key of the new connection and the
+// connection, leased. When q may run on another endpoint, the endpoint of
+// cacheKey is marked unhealthy and q moves to the next healthy endpoint.
+// Otherwise, or when no other endpoint is healthy, dbConn is replaced by a
+// new connection to the same endpoint, as Reconnect does.
+func (c *Connector) failover(ctx context.Context, dbConn CachedConnection, q *Query, cacheKey string) (string, CachedConnection, error) {
+	if c.endpoints != nil && c.routable(q) {
+		base, failed := c.splitEndpointKey(cacheKey)
+		c.endpoints.markUnhealthy(failed)
+		if key, endpoint := c.pickEndpoint(base, q); endpoint != failed {
+			var connectionArgs json.RawMessage
+			if base != c.defaultKey {
+				connectionArgs = q.ConnectionArgs
+			}
+			key, conn, _, err := c.leaseOrConnect(ctx, key, connectionArgs, endpoint)
+			return key, conn, err
+		}
+	}
+	conn, err := c.reconnect(ctx, dbConn, q, cacheKey)
+	return cacheKey, conn, err
+}
+
+// checkEndpoints pings every endpoint of a MultiEndpointDriver other than
+// endpoint 0, which Connect checks, connecting to it first if needed. An
+// endpoint failing is marked unhealthy, and the errors of all failing
+// endpoints are returned.
+func (c *Connector) checkEndpoints(ctx context.Context) error {
+	if c.endpoints == nil {
+		return nil
+	}
+	var errs []error
+	for endpoint := 1; endpoint < len(c.endpoints.latency); endpoint++ {
+		key, conn, _, err := c.leaseOrConnect(ctx, endpointKey(c.defaultKey, endpoint), nil, endpoint)
+		if err == nil {
+			err = c.pingEndpoint(ctx, key, conn)
+			conn.Release()
+		} else {
+			c.endpoints.markUnhealthy(endpoint)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("endpoint %d: %w", endpoint, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// open connects to endpoint.
+func (c *Connector) open(ctx context.Context, settings backend.DataSourceInstanceSettings, connectionArgs json.RawMessage, endpoint int) (*sql.DB, error) {
+	if endpoint == 0 {
+		return c.driver.Connect(ctx, settings, connectionArgs)
+	}
+	return c.driver.(MultiEndpointDriver).ConnectEndpoint(ctx, settings, connectionArgs, endpoint)
+}
+
+// pingEndpoint pings conn, cached under key, recording the latency of its
+// endpoint, or marking the endpoint unhealthy when the ping fails.
+func (c *Connector) pingEndpoint(ctx context.Context, key string, conn CachedConnection) error {
+	start := time.Now()
+	err := c.ping(ctx, conn)
+	if c.endpoints != nil {
+		if err != nil {
+			c.endpoints.markUnhealthy(c.endpointOf(key))
+		} else {
+			c.endpoints.observeLatency(c.endpointOf(key), time.Since(start))
+		}
+	}
+	return err
+}
+
+// measureEndpoint pings conn, cached under key, when routing by latency and
+// its endpoint has not been measured yet.
+func (c *Connector) measureEndpoint(ctx context.Context, key string, conn CachedConnection) {
+	if c.endpoints == nil || c.endpoints.routing != EndpointLowestLatency || c.endpoints.measured(c.endpointOf(key)) {
+		return
+	}
+	_ = c.pingEndpoint(ctx, key, conn)
+}
+
+// recordEndpoint marks the endpoint of the connection cached under key
+// unhealthy when err is a connection error: one the driver or RetryOn
+// classify as needing a reconnect, or driver.ErrBadConn.
+func (c *Connector) recordEndpoint(key string, err error) {
+	if c.endpoints == nil || err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	classifier, _ := c.driver.(RetryClassifier)
+	if errors.Is(err, driver.ErrBadConn) || classifyRetry(classifier, c.driverSettings.RetryOn, err) == RetryWithReconnect {
+		c.endpoints.markUnhealthy(c.endpointOf(key))
+	}
+}
@@ -0,0 +1,381 @@
+package sqlds
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func picks(s *endpointSet, n int) []int {
+	var got []int
+	for range n {
+		got = append(got, s.pick())
+	}
+	return got
+}
+
+func equalPicks(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestEndpointSet_RoundRobin(t *testing.T) {
+	s := newEndpointSet(3, "", 0)
+	if got := picks(s, 4); !equalPicks(got, []int{0, 1, 2, 0}) {
+		t.Fatalf("expected the endpoints in turn, got %v", got)
+	}
+	s.markUnhealthy(1)
+	if got := picks(s, 4); !equalPicks(got, []int{2, 0, 2, 0}) {
+		t.Fatalf("expected the unhealthy endpoint to be skipped, got %v", got)
+	}
+}
+
+func TestEndpointSet_AllUnhealthy(t *testing.T) {
+	s := newEndpointSet(3, EndpointRoundRobin, time.Minute)
+	s.markUnhealthy(2)
+	s.markUnhealthy(0)
+	s.markUnhealthy(1)
+	if got := s.pick(); got != 2 {
+		t.Fatalf("expected the endpoint whose cooldown ends first, got %d", got)
+	}
+}
+
+func TestEndpointSet_CooldownEnds(t *testing.T) {
+	s := newEndpointSet(2, EndpointRoundRobin, 10*time.Millisecond)
+	s.markUnhealthy(1)
+	if got := picks(s, 2); !equalPicks(got, []int{0, 0}) {
+		t.Fatalf("expected the unhealthy endpoint to be skipped, got %v", got)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if got := picks(s, 2); !equalPicks(got, []int{1, 0}) {
+		t.Fatalf("expected the endpoint back after its cooldown, got %v", got)
+	}
+}
+
+func TestEndpointSet_LowestLatency(t *testing.T) {
+	s := newEndpointSet(3, EndpointLowestLatency, 0)
+	s.observeLatency(0, 5*time.Millisecond)
+	if got := s.pick(); got != 1 {
+		t.Fatalf("expected an unmeasured endpoint first, got %d", got)
+	}
+	s.observeLatency(1, time.Millisecond)
+	s.observeLatency(2, 3*time.Millisecond)
+	if got := picks(s, 2); !equalPicks(got, []int{1, 1}) {
+		t.Fatalf("expected the fastest endpoint, got %v", got)
+	}
+	s.markUnhealthy(1)
+	if got := s.pick(); got != 2 {
+		t.Fatalf("expected the fastest healthy endpoint, got %d", got)
+	}
+}
+
+func TestNewEndpointSet_UnknownRouting(t *testing.T) {
+	if s := newEndpointSet(2, "random", 0); s.routing != EndpointRoundRobin || s.cooldown != defaultEndpointCooldown {
+		t.Fatalf("expected the defaults, got %q and %s", s.routing, s.cooldown)
+	}
+}
+
+// multiEndpointDriver has three endpoints, recording those connected to.
+type multiEndpointDriver struct {
+	noopDriver
+	settings DriverSettings
+
+	// down is an endpoint whose connections fail their pings, if not 0.
+	down int
+
+	mu        sync.Mutex
+	connected []int
+}
+
+func (d *multiEndpointDriver) Settings(_ context.Context, _ backend.DataSourceInstanceSettings) DriverSettings {
+	return d.settings
+}
+
+func (d *multiEndpointDriver) Connect(ctx context.Context, settings backend.DataSourceInstanceSettings, args json.RawMessage) (*sql.DB, error) {
+	return d.ConnectEndpoint(ctx, settings, args, 0)
+}
+
+func (d *multiEndpointDriver) Endpoints(_ backend.DataSourceInstanceSettings) int { return 3 }
+
+func (d *multiEndpointDriver) ConnectEndpoint(_ context.Context, _ backend.DataSourceInstanceSettings, _ json.RawMessage, endpoint int) (*sql.DB, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.connected = append(d.connected, endpoint)
+	if endpoint != 0 && endpoint == d.down {
+		return sql.OpenDB(expiredConnector{}), nil
+	}
+	return sql.OpenDB(aliveConnector{}), nil
+}
+
+func (d *multiEndpointDriver) connects() []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]int(nil), d.connected...)
+}
+
+func newMultiEndpointConnector(t *testing.T, d *multiEndpointDriver) *Connector {
+	t.Helper()
+	conn, err := NewConnector(context.Background(), d, backend.DataSourceInstanceSettings{UID: "multi"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Dispose)
+	return conn
+}
+
+func queryEndpoint(t *testing.T, conn *Connector, q *Query) string {
+	t.Helper()
+	key, dbConn, err := conn.GetConnectionFromQuery(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbConn.Release()
+	return key
+}
+
+// readQuery is a query spread across the endpoints.
+var readQuery = &Query{RawSQL: "SELECT 1"}
+
+func TestConnector_RoutesAcrossEndpoints(t *testing.T) {
+	d := &multiEndpointDriver{}
+	conn := newMultiEndpointConnector(t, d)
+
+	var keys []string
+	for range 4 {
+		keys = append(keys, queryEndpoint(t, conn, readQuery))
+	}
+	want := []string{"multi-default", "multi-default-endpoint1", "multi-default-endpoint2", "multi-default"}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Fatalf("expected keys %v, got %v", want, keys)
+		}
+	}
+	if got := d.connects(); !equalPicks(got, []int{0, 1, 2}) {
+		t.Fatalf("expected a connection to each endpoint, got %v", got)
+	}
+
+	argsKey := queryEndpoint(t, conn, &Query{RawSQL: "SELECT 1", ConnectionArgs: json.RawMessage(`{"db":"a"}`)})
+	if want := keyWithConnectionArgs(conn.UID, json.RawMessage(`{"db":"a"}`)) + "-endpoint1"; argsKey != want {
+		t.Fatalf("expected ConnectionArgs to be routed too, got key %s", argsKey)
+	}
+
+	// Reconnecting replaces the connection to the same endpoint.
+	dbConn, _ := conn.getDBConnection(keys[2])
+	if _, err := conn.Reconnect(context.Background(), dbConn, &Query{}, keys[2]); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.connects(); got[len(got)-1] != 2 {
+		t.Fatalf("expected Reconnect to connect to endpoint 2, got %v", got)
+	}
+}
+
+func TestConnector_EndpointOf(t *testing.T) {
+	conn := newMultiEndpointConnector(t, &multiEndpointDriver{})
+	argsKey := keyWithConnectionArgs(conn.UID, json.RawMessage(`{"db":"a"}`))
+	for key, want := range map[string]int{
+		conn.defaultKey:                  0,
+		conn.defaultKey + "-endpoint2":   2,
+		argsKey:                          0,
+		argsKey + "-endpoint1":           1,
+		conn.defaultKey + "-endpoint3":   0,
+		conn.defaultKey + "-endpointabc": 0,
+	} {
+		if got := conn.endpointOf(key); got != want {
+			t.Errorf("endpointOf(%q) = %d, want %d", key, got, want)
+		}
+	}
+}
+
+func TestConnector_SkipsEndpointsWithConnectionErrors(t *testing.T) {
+	d := &multiEndpointDriver{settings: DriverSettings{RetryOn: []string{"connection refused"}}}
+	conn := newMultiEndpointConnector(t, d)
+	key1 := "multi-default-endpoint1"
+	queryEndpoint(t, conn, readQuery)
+	if got := queryEndpoint(t, conn, readQuery); got != key1 {
+		t.Fatalf("expected endpoint 1, got %s", got)
+	}
+
+	conn.recordQuery(key1, errors.New("syntax error"))
+	conn.recordQuery(key1, context.Canceled)
+	if got := picks(conn.endpoints, 3); !equalPicks(got, []int{2, 0, 1}) {
+		t.Fatalf("expected errors other than connection errors to keep the endpoint healthy, got %v", got)
+	}
+
+	conn.recordQuery(key1, errors.New("dial tcp: connection refused"))
+	for range 4 {
+		if got := queryEndpoint(t, conn, readQuery); got == key1 {
+			t.Fatal("expected the endpoint with a connection error to be skipped")
+		}
+	}
+
+	key2 := "multi-default-endpoint2"
+	conn.recordQuery(key2, driver.ErrBadConn)
+	for range 2 {
+		if got := queryEndpoint(t, conn, readQuery); got != conn.defaultKey {
+			t.Fatalf("expected only endpoint 0 to be healthy, got %s", got)
+		}
+	}
+}
+
+func TestConnector_SkipsEndpointsFailingPing(t *testing.T) {
+	d := &multiEndpointDriver{settings: DriverSettings{EndpointRouting: EndpointLowestLatency}}
+	conn := newMultiEndpointConnector(t, d)
+
+	// The first queries measure every endpoint.
+	for range 3 {
+		queryEndpoint(t, conn, readQuery)
+	}
+	for endpoint := range 3 {
+		if !conn.endpoints.measured(endpoint) {
+			t.Fatalf("expected endpoint %d to be measured", endpoint)
+		}
+	}
+
+	failing := CachedConnection{db: sql.OpenDB(expiredConnector{})}
+	if err := conn.pingEndpoint(context.Background(), conn.defaultKey, failing); err == nil {
+		t.Fatal("expected the ping to fail")
+	}
+	for range 3 {
+		if got := queryEndpoint(t, conn, readQuery); got == conn.defaultKey {
+			t.Fatal("expected the endpoint failing its ping to be skipped")
+		}
+	}
+}
+
+func TestConnector_SingleEndpoint(t *testing.T) {
+	conn := newLeaseTestConnector(t)
+	if conn.endpoints != nil {
+		t.Fatal("expected no endpoint routing for drivers with a single endpoint")
+	}
+}
+
+func TestIsReadQuery(t *testing.T) {
+	for sql, want := range map[string]bool{
+		"SELECT * FROM t":                                true,
+		"  -- report\nselect 1;":                         true,
+		"(SELECT 1) UNION (SELECT 2)":                    true,
+		"WITH x AS (SELECT 1) SELECT * FROM x":           true,
+		"SELECT 'insert into t' FROM t":                  true,
+		"SELECT last_update FROM t":                      true,
+		"":                                               false,
+		"INSERT INTO t VALUES (1)":                       false,
+		"UPDATE t SET v = 1":                             false,
+		"SELECT * INTO t2 FROM t":                        false,
+		"SELECT * FROM t FOR UPDATE":                     false,
+		"SELECT * FROM t LOCK IN SHARE MODE":             false,
+		"WITH x AS (DELETE FROM t RETURNING *) SELECT 1": false,
+		"SELECT 1; SELECT 2":                             false,
+		"SET @x = 1; SELECT @x":                          false,
+		"EXEC report":                                    false,
+	} {
+		if got := isReadQuery(sql, false); got != want {
+			t.Errorf("isReadQuery(%q) = %v, want %v", sql, got, want)
+		}
+	}
+}
+
+// replicaRouterDriver routes the queries it is told to.
+type replicaRouterDriver struct {
+	*multiEndpointDriver
+}
+
+func (d replicaRouterDriver) UseReplica(q *Query) bool {
+	return q.RawSQL == "EXEC report"
+}
+
+func TestConnector_RoutesOnlyReadQueries(t *testing.T) {
+	conn := newMultiEndpointConnector(t, &multiEndpointDriver{})
+	for _, sql := range []string{"INSERT INTO t VALUES (1)", "SET @x = 1; SELECT @x", "EXEC report"} {
+		for range 3 {
+			if got := queryEndpoint(t, conn, &Query{RawSQL: sql}); got != conn.defaultKey {
+				t.Fatalf("expected %q to run on endpoint 0, got %s", sql, got)
+			}
+		}
+	}
+
+	routed, err := NewConnector(context.Background(), replicaRouterDriver{&multiEndpointDriver{}}, backend.DataSourceInstanceSettings{UID: "router"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(routed.Dispose)
+	queryEndpoint(t, routed, &Query{RawSQL: "EXEC report"})
+	if got := queryEndpoint(t, routed, &Query{RawSQL: "EXEC report"}); got != "router-default-endpoint1" {
+		t.Fatalf("expected the ReplicaRouter to route the query, got %s", got)
+	}
+	if got := queryEndpoint(t, routed, readQuery); got != routed.defaultKey {
+		t.Fatalf("expected the ReplicaRouter to keep other queries on endpoint 0, got %s", got)
+	}
+}
+
+func TestConnector_FailsOverToNextEndpoint(t *testing.T) {
+	d := &multiEndpointDriver{}
+	conn := newMultiEndpointConnector(t, d)
+	queryEndpoint(t, conn, readQuery)
+	key, dbConn, err := conn.GetConnectionFromQuery(context.Background(), readQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbConn.Release()
+	if key != "multi-default-endpoint1" {
+		t.Fatalf("expected endpoint 1, got %s", key)
+	}
+
+	newKey, newConn, err := conn.failover(context.Background(), dbConn, readQuery, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newConn.Release()
+	if newKey != "multi-default-endpoint2" {
+		t.Fatalf("expected the query to fail over to endpoint 2, got %s", newKey)
+	}
+	if got := picks(conn.endpoints, 2); !equalPicks(got, []int{0, 2}) {
+		t.Fatalf("expected the failed endpoint to be skipped, got %v", got)
+	}
+
+	// Queries that only run on endpoint 0 reconnect to it.
+	write := &Query{RawSQL: "DELETE FROM t"}
+	defaultConn, _ := conn.getDBConnection(conn.defaultKey)
+	before := len(d.connects())
+	newKey, newConn, err = conn.failover(context.Background(), defaultConn, write, conn.defaultKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newConn.Release()
+	if got := d.connects(); newKey != conn.defaultKey || len(got) != before+1 || got[len(got)-1] != 0 {
+		t.Fatalf("expected a reconnect to endpoint 0, got key %s and connects %v", newKey, got)
+	}
+}
+
+func TestConnector_CheckEndpoints(t *testing.T) {
+	conn := newMultiEndpointConnector(t, &multiEndpointDriver{down: 2})
+	err := conn.checkEndpoints(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "endpoint 2") || strings.Contains(err.Error(), "endpoint 1") {
+		t.Fatalf("expected only endpoint 2 to fail, got %v", err)
+	}
+	if got := picks(conn.endpoints, 3); !equalPicks(got, []int{0, 1, 0}) {
+		t.Fatalf("expected the failing endpoint to be skipped, got %v", got)
+	}
+
+	res, err := (&HealthChecker{Connector: conn}).Check(context.Background(), &backend.CheckHealthRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != backend.HealthStatusError || !strings.Contains(res.Message, "endpoint 2") {
+		t.Fatalf("expected the health check to fail on endpoint 2, got %s: %s", res.Status, res.Message)
+	}
+}
@@ -0,0 +1,101 @@
+package sqlds
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// queryHashMetaKey is the key of the query fingerprint in the custom metadata
+// of frames.
+const queryHashMetaKey = "queryHash"
+
+// QueryFingerprint returns a stable hash of the shape of sql: queries that
+// differ only in literal values, the length of IN lists, whitespace, comments
+// or the case of keywords and unquoted identifiers share a fingerprint. It
+// identifies a query in logs and metadata without revealing its literals.
+func QueryFingerprint(sql string) string {
+	sum := sha256.Sum256([]byte(normalizeSQL(sql)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// normalizeSQL reduces sql to its shape: comments are dropped, literals and
+// bind parameters become ?, lists made only of them collapse to (?),
+// unquoted words are lowercased and tokens are separated by single spaces.
+func normalizeSQL(sql string) string {
+	var tokens []string
+	for _, token := range lexSQL(sql, false) {
+		switch token.kind {
+		case sqlSpace, sqlComment:
+		case sqlString, sqlNumber, sqlParam:
+			tokens = append(tokens, "?")
+		case sqlWord:
+			tokens = append(tokens, strings.ToLower(token.text))
+		default:
+			tokens = append(tokens, token.text)
+		}
+	}
+	return strings.Join(collapseLists(tokens), " ")
+}
+
+// collapseLists replaces every parenthesized list made only of ?, possibly
+// negated, with a single (?), so that IN lists of any length normalize alike.
+func collapseLists(tokens []string) []string {
+	out := tokens[:0]
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i] == "(" {
+			if end, ok := placeholderList(tokens, i+1); ok {
+				out = append(out, "(", "?", ")")
+				i = end
+				continue
+			}
+		}
+		out = append(out, tokens[i])
+	}
+	return out
+}
+
+// placeholderList reports whether tokens[start:] begins with a
+// comma-separated list of ? closed by ), returning the index of the ).
+func placeholderList(tokens []string, start int) (int, bool) {
+	i := start
+	for {
+		if i < len(tokens) && (tokens[i] == "-" || tokens[i] == "+") {
+			i++
+		}
+		if i >= len(tokens) || tokens[i] != "?" {
+			return 0, false
+		}
+		i++
+		if i >= len(tokens) {
+			return 0, false
+		}
+		switch tokens[i] {
+		case ")":
+			return i, true
+		case ",":
+			i++
+		default:
+			return 0, false
+		}
+	}
+}
+
+// setFrameQueryHash adds the query fingerprint to the custom metadata of
+// frame, unless the custom metadata is already something other than a map.
+func setFrameQueryHash(frame *data.Frame, hash string) {
+	if frame == nil {
+		return
+	}
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+	switch custom := frame.Meta.Custom.(type) {
+	case nil:
+		frame.Meta.Custom = map[string]any{queryHashMetaKey: hash}
+	case map[string]any:
+		custom[queryHashMetaKey] = hash
+	}
+}
@@ -0,0 +1,57 @@
+package sqlds
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestNormalizeSQL(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{sql: "SELECT 1", want: "select ?"},
+		{sql: "select  *\n\tFROM t WHERE name = 'it''s' AND v > -1.5e3", want: "select * from t where name = ? and v > - ?"},
+		{sql: "SELECT a FROM t WHERE id IN (1, 2, 3)", want: "select a from t where id in ( ? )"},
+		{sql: "SELECT a FROM t WHERE id IN ($1, $2) AND b = ?", want: "select a from t where id in ( ? ) and b = ?"},
+		{sql: "SELECT count(a), max(b + 1) FROM t", want: "select count ( a ) , max ( b + ? ) from t"},
+		{sql: "SELECT \"Mixed Case\", `x` FROM t2 -- note 'x'\n/* 42 */", want: "select \"Mixed Case\" , `x` from t2"},
+		{sql: "SELECT $tag$body$tag$::text", want: "select ? : : text"},
+		{sql: `SELECT "a"" -- b" FROM t WHERE x = :1`, want: `select "a"" -- b" from t where x = ?`},
+	}
+	for _, tt := range tests {
+		if got := normalizeSQL(tt.sql); got != tt.want {
+			t.Errorf("normalizeSQL(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestQueryFingerprint(t *testing.T) {
+	a := QueryFingerprint("SELECT * FROM orders WHERE customer = 'alice' AND id IN (1, 2, 3)")
+	b := QueryFingerprint("select *\nfrom orders -- by customer\nwhere customer = 'bob' and id in (7)")
+	if a != b {
+		t.Fatalf("expected queries of the same shape to share a fingerprint, got %s and %s", a, b)
+	}
+	if c := QueryFingerprint("SELECT * FROM customers WHERE customer = 'alice'"); c == a {
+		t.Fatal("expected queries of different shapes to have different fingerprints")
+	}
+	if len(a) != 16 {
+		t.Fatalf("expected a 16 character fingerprint, got %q", a)
+	}
+}
+
+func TestSetFrameQueryHash(t *testing.T) {
+	frame := data.NewFrame("A")
+	setFrameQueryHash(frame, "abc")
+	custom, ok := frame.Meta.Custom.(map[string]any)
+	if !ok || custom[queryHashMetaKey] != "abc" {
+		t.Fatalf("expected the hash in the custom metadata, got %#v", frame.Meta.Custom)
+	}
+
+	frame.Meta.Custom = struct{ Other string }{"driver"}
+	setFrameQueryHash(frame, "abc")
+	if _, ok := frame.Meta.Custom.(struct{ Other string }); !ok {
+		t.Fatalf("expected custom metadata set by the driver to be kept, got %#v", frame.Meta.Custom)
+	}
+}
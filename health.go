@@ -2,6 +2,7 @@ package sqlds
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -24,7 +25,13 @@ func (hc *HealthChecker) Check(ctx context.Context, req *backend.CheckHealthRequ
 	}
 	if _, err := hc.Connector.Connect(ctx, req.GetHTTPHeaders()); err != nil {
 		hc.Metrics.CollectDuration(SourceDownstream, StatusError, time.Since(start).Seconds())
-		return &backend.CheckHealthResult{Status: backend.HealthStatusError, Message: err.Error()}, nil
+		return &backend.CheckHealthResult{Status: backend.HealthStatusError, Message: err.Error(), JSONDetails: hc.breakerDetails()}, nil
+	}
+	// The other endpoints of a MultiEndpointDriver serve queries too, so a
+	// misconfigured replica fails the check as well.
+	if err := hc.Connector.checkEndpoints(ctx); err != nil {
+		hc.Metrics.CollectDuration(SourceDownstream, StatusError, time.Since(start).Seconds())
+		return &backend.CheckHealthResult{Status: backend.HealthStatusError, Message: err.Error(), JSONDetails: hc.breakerDetails()}, nil
 	}
 	if hc.PostCheckHealth != nil {
 		if res := hc.PostCheckHealth(ctx, req); res != nil && res.Status == backend.HealthStatusError {
@@ -33,5 +40,25 @@ func (hc *HealthChecker) Check(ctx context.Context, req *backend.CheckHealthRequ
 		}
 	}
 	hc.Metrics.CollectDuration(SourceDownstream, StatusOK, time.Since(start).Seconds())
-	return &backend.CheckHealthResult{Status: backend.HealthStatusOk, Message: "Data source is working"}, nil
+	return &backend.CheckHealthResult{Status: backend.HealthStatusOk, Message: "Data source is working", JSONDetails: hc.breakerDetails()}, nil
+}
+
+// breakerDetails reports the state of every circuit breaker as
+// {"circuitBreakers": {"<connection key hash>": "<state>"}}, or nil when
+// circuit breaking is disabled. Keys are hashed, as tracing and the audit log
+// hash them, since they may be derived from connection arguments. A passing
+// health check closes the breaker of the default connection.
+func (hc *HealthChecker) breakerDetails() []byte {
+	if hc.Connector == nil || hc.Connector.driverSettings.CircuitBreakerFailures <= 0 {
+		return nil
+	}
+	states := map[string]BreakerState{}
+	for key, state := range hc.Connector.breakerStates() {
+		states[connectionKeyHash(key)] = state
+	}
+	details, err := json.Marshal(map[string]any{"circuitBreakers": states})
+	if err != nil {
+		return nil
+	}
+	return details
 }
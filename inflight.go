@@ -0,0 +1,164 @@
+package sqlds
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// inflightRun is a query run shared by identical in-flight queries.
+type inflightRun struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	// refID is the RefID of the query that started the run, which its
+	// frames are named after.
+	refID string
+	// waiters counts the callers waiting for the run, and shared records
+	// whether it ever had more than one.
+	waiters int
+	shared  bool
+
+	done   chan struct{}
+	frames data.Frames
+	err    error
+}
+
+// inflightQueries tracks the runs of in-flight queries by key. The zero
+// value is ready to use.
+type inflightQueries struct {
+	mu   sync.Mutex
+	runs map[string]*inflightRun
+}
+
+// join adds a waiter to the run in flight for key, or starts a new run,
+// reporting whether it did. A new run is detached from the cancellation of
+// ctx, keeping only its deadline.
+func (f *inflightQueries) join(ctx context.Context, key, refID string) (*inflightRun, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r, ok := f.runs[key]; ok {
+		r.waiters++
+		r.shared = true
+		return r, false
+	}
+
+	runCtx := context.WithoutCancel(ctx)
+	var cancel context.CancelFunc
+	if deadline, ok := ctx.Deadline(); ok {
+		runCtx, cancel = context.WithDeadline(runCtx, deadline)
+	} else {
+		runCtx, cancel = context.WithCancel(runCtx)
+	}
+	r := &inflightRun{ctx: runCtx, cancel: cancel, refID: refID, waiters: 1, done: make(chan struct{})}
+	if f.runs == nil {
+		f.runs = map[string]*inflightRun{}
+	}
+	f.runs[key] = r
+	return r, true
+}
+
+// leave removes a waiter from r. The run is canceled once every waiter has
+// left, and later callers start a new one.
+func (f *inflightQueries) leave(key string, r *inflightRun) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r.waiters--
+	if r.waiters == 0 {
+		f.removeLocked(key, r)
+		r.cancel()
+	}
+}
+
+// finish records the result of r and wakes its waiters.
+func (f *inflightQueries) finish(key string, r *inflightRun, frames data.Frames, err error) {
+	f.mu.Lock()
+	f.removeLocked(key, r)
+	f.mu.Unlock()
+	r.frames, r.err = frames, err
+	close(r.done)
+	r.cancel()
+}
+
+func (f *inflightQueries) removeLocked(key string, r *inflightRun) {
+	if f.runs[key] == r {
+		delete(f.runs, key)
+	}
+}
+
+// runDeduplicated runs run at most once at a time per key: callers arriving
+// while a run for the same key is in flight wait for it and share its result
+// instead of starting their own. Every caller of a shared run receives its own
+// copy of the frames, renamed after its refID, so a ResponseMutator mutating
+// one response cannot corrupt another. joined reports whether the caller
+// waited for a run another caller started.
+//
+// The shared run is detached from the cancellation of whichever caller
+// started it, keeping only that caller's deadline, so one user closing a
+// panel does not fail the identical queries of everyone else. Each caller
+// still stops waiting when its own context is done, and the run is canceled
+// once the last of them has. run outlives the caller that started it, so it
+// must not use anything that caller releases when it returns.
+func (ds *SQLDatasource) runDeduplicated(ctx context.Context, key, refID string, run func(context.Context) (data.Frames, error)) (_ data.Frames, joined bool, _ error) {
+	r, started := ds.inflight.join(ctx, key, refID)
+	if started {
+		go func() {
+			frames, err := run(r.ctx)
+			ds.inflight.finish(key, r, frames, err)
+		}()
+	}
+	defer ds.inflight.leave(key, r)
+
+	select {
+	case <-ctx.Done():
+		return nil, !started, backend.DownstreamError(ctx.Err())
+	case <-r.done:
+		if !r.shared || r.frames == nil {
+			return r.frames, !started, r.err
+		}
+		copied, err := copyFrames(r.frames)
+		if err != nil {
+			return nil, !started, err
+		}
+		renameFrames(copied, r.refID, refID)
+		return copied, !started, r.err
+	}
+}
+
+// inflightKey keys in-flight query deduplication. It digests what
+// resultCacheKey does except the RefID, so the identical queries of
+// different panels share a run too.
+func inflightKey(q *Query, connKey string, fillMode *data.FillMissing, rowLimit int64, args []interface{}) string {
+	return queryDigest(q, connKey, fillMode, rowLimit, args, "")
+}
+
+// renameFrames renames the frames named after the RefID from, including the
+// from-<index> frames of multiple result sets and statements, after to.
+func renameFrames(frames data.Frames, from, to string) {
+	if from == to {
+		return
+	}
+	for _, frame := range frames {
+		switch {
+		case frame.Name == from:
+			frame.Name = to
+		case strings.HasPrefix(frame.Name, from+"-"):
+			frame.Name = to + frame.Name[len(from):]
+		}
+		if frame.RefID == from {
+			frame.RefID = to
+		}
+	}
+}
+
+// copyFrames returns a deep copy of frames, including field configs, labels
+// and frame metadata, by round-tripping them through Arrow.
+func copyFrames(frames data.Frames) (data.Frames, error) {
+	encoded, err := frames.MarshalArrow()
+	if err != nil {
+		return nil, err
+	}
+	return data.UnmarshalArrowFrames(encoded)
+}
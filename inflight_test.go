@@ -0,0 +1,158 @@
+package sqlds
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestRunDeduplicated_SharesOneRun(t *testing.T) {
+	ds := &SQLDatasource{}
+	release := make(chan struct{})
+	var runs atomic.Int32
+	run := func(context.Context) (data.Frames, error) {
+		runs.Add(1)
+		<-release
+		return data.Frames{data.NewFrame("A", data.NewField("v", nil, []int64{1}))}, nil
+	}
+
+	const callers = 5
+	results := make([]data.Frames, callers)
+	var joiners atomic.Int32
+	var wg sync.WaitGroup
+	wg.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			res, joined, err := ds.runDeduplicated(context.Background(), "key", "A", run)
+			if err != nil {
+				t.Errorf("caller %d: unexpected error %v", i, err)
+			}
+			if joined {
+				joiners.Add(1)
+			}
+			results[i] = res
+		}(i)
+	}
+	// Give every caller time to join the in-flight run before releasing it.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := runs.Load(); n != 1 {
+		t.Fatalf("run invoked %d times, want 1", n)
+	}
+	if n := joiners.Load(); n != callers-1 {
+		t.Fatalf("%d callers joined the run, want %d", n, callers-1)
+	}
+	results[0][0].Fields[0].Set(0, int64(42))
+	for i := 1; i < callers; i++ {
+		if got := results[i][0].Fields[0].At(0).(int64); got != 1 {
+			t.Fatalf("caller %d saw a mutation made through caller 0's frames: got %d", i, got)
+		}
+	}
+}
+
+func TestRunDeduplicated_SharesErrors(t *testing.T) {
+	ds := &SQLDatasource{}
+	want := errors.New("boom")
+	_, _, err := ds.runDeduplicated(context.Background(), "key", "A", func(context.Context) (data.Frames, error) {
+		return nil, want
+	})
+	if !errors.Is(err, want) {
+		t.Fatalf("got %v want %v", err, want)
+	}
+}
+
+func TestRunDeduplicated_LeaderCancelDoesNotCancelRun(t *testing.T) {
+	ds := &SQLDatasource{}
+	release := make(chan struct{})
+	runErr := make(chan error, 1)
+	run := func(ctx context.Context) (data.Frames, error) {
+		<-release
+		runErr <- ctx.Err()
+		return data.Frames{data.NewFrame("A", data.NewField("v", nil, []int64{1}))}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, _, err := ds.runDeduplicated(ctx, "key", "A", run)
+		leader <- err
+	}()
+	waitFor(t, func() bool { return waiters(ds, "key") == 1 })
+	follower := make(chan data.Frames, 1)
+	go func() {
+		res, _, err := ds.runDeduplicated(context.Background(), "key", "B", run)
+		if err != nil {
+			t.Error(err)
+		}
+		follower <- res
+	}()
+	waitFor(t, func() bool { return waiters(ds, "key") == 2 })
+
+	cancel()
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the canceled leader to return context.Canceled, got %v", err)
+	}
+	close(release)
+	if err := <-runErr; err != nil {
+		t.Fatalf("shared run observed the leader's cancellation: %v", err)
+	}
+	res := <-follower
+	if len(res) != 1 || res[0].Name != "B" {
+		t.Fatalf("expected the follower to get its frames named after its RefID, got %v", res)
+	}
+}
+
+func TestRunDeduplicated_LastWaiterCancelsRun(t *testing.T) {
+	ds := &SQLDatasource{}
+	runErr := make(chan error, 1)
+	run := func(ctx context.Context) (data.Frames, error) {
+		<-ctx.Done()
+		runErr <- ctx.Err()
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := ds.runDeduplicated(ctx, "key", "A", run)
+		done <- err
+	}()
+	waitFor(t, func() bool { return waiters(ds, "key") == 1 })
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the canceled caller to return context.Canceled, got %v", err)
+	}
+	if err := <-runErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the run to be canceled once no caller waits for it, got %v", err)
+	}
+	if n := waiters(ds, "key"); n != 0 {
+		t.Fatalf("expected the canceled run to be forgotten, got %d waiters", n)
+	}
+}
+
+func TestRenameFrames(t *testing.T) {
+	frames := data.Frames{data.NewFrame("A"), data.NewFrame("A-1"), data.NewFrame("AB")}
+	renameFrames(frames, "A", "C")
+	for i, want := range []string{"C", "C-1", "AB"} {
+		if frames[i].Name != want {
+			t.Fatalf("frame %d: got %q want %q", i, frames[i].Name, want)
+		}
+	}
+}
+
+func waiters(ds *SQLDatasource, key string) int {
+	ds.inflight.mu.Lock()
+	defer ds.inflight.mu.Unlock()
+	if r, ok := ds.inflight.runs[key]; ok {
+		return r.waiters
+	}
+	return 0
+}
@@ -0,0 +1,131 @@
+package sqlds
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// CredentialRefresher is an additional interface that could be implemented by driver.
+// This adds the ability to the driver to renew the credentials of a cached
+// connection, such as a session or OAuth token, before they expire, for
+// engines that drop idle sessions after a few hours. It is only used when
+// DriverSettings.KeepaliveInterval is set: the keepalive worker calls
+// RefreshCredentials once CredentialsExpiry falls within two intervals, and
+// replaces the connection through Driver.Connect when the refresh fails.
+type CredentialRefresher interface {
+	// CredentialsExpiry returns when the credentials of db expire, or the
+	// zero time when it is unknown.
+	CredentialsExpiry(db *sql.DB) time.Time
+	RefreshCredentials(ctx context.Context, db *sql.DB) error
+}
+
+// keepalive is the background worker pinging the cached connections of a
+// Connector every DriverSettings.KeepaliveInterval.
+type keepalive struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// startKeepalive starts the keepalive worker when
+// DriverSettings.KeepaliveInterval is set.
+func (c *Connector) startKeepalive() {
+	interval := c.driverSettings.KeepaliveInterval
+	if interval <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.keepalive = &keepalive{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(c.keepalive.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.keepAlive(ctx)
+			}
+		}
+	}()
+}
+
+// stopKeepalive stops the keepalive worker and waits for it to return.
+func (c *Connector) stopKeepalive() {
+	if c.keepalive == nil {
+		return
+	}
+	c.keepalive.cancel()
+	<-c.keepalive.done
+}
+
+// keepAlive refreshes the credentials of the cached connections about to
+// expire and pings the others, replacing those that fail.
+func (c *Connector) keepAlive(ctx context.Context) {
+	type entry struct {
+		key  string
+		conn CachedConnection
+	}
+	// Collect the entries first, as reconnecting stores into the cache.
+	var entries []entry
+	c.connCache().Range(func(key string, conn CachedConnection) bool {
+		entries = append(entries, entry{key, conn})
+		return true
+	})
+
+	refresher, _ := c.driver.(CredentialRefresher)
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		conn, ok := c.lease(e.conn)
+		if !ok {
+			continue
+		}
+		checkCtx, cancel := c.keepaliveContext(ctx)
+		err := c.keepConnectionAlive(checkCtx, refresher, e.key, conn)
+		cancel()
+		if err != nil {
+			backend.Logger.Warn(fmt.Sprintf("keepalive failed: %s. Reconnecting", err.Error()), "connectionKey", connectionKeyHash(e.key))
+			reconnectCtx, cancel := c.keepaliveContext(ctx)
+			if replacement, err := c.reconnect(reconnectCtx, conn, &Query{ConnectionArgs: conn.connectionArgs}, e.key); err != nil {
+				backend.Logger.Warn(fmt.Sprintf("keepalive reconnect failed: %s", err.Error()), "connectionKey", connectionKeyHash(e.key))
+			} else {
+				replacement.Release()
+			}
+			cancel()
+		}
+		conn.Release()
+	}
+}
+
+// keepaliveContext bounds a step of keeping a connection alive by
+// DriverSettings.Timeout or, when it is not set, by the keepalive interval,
+// so that a connection that hangs cannot stall the worker, which handles the
+// connections one at a time.
+func (c *Connector) keepaliveContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := c.driverSettings.Timeout
+	if timeout <= 0 {
+		timeout = c.driverSettings.KeepaliveInterval
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// keepConnectionAlive refreshes the credentials of conn, cached under key,
+// when they expire within two keepalive intervals, and pings it otherwise.
+func (c *Connector) keepConnectionAlive(ctx context.Context, refresher CredentialRefresher, key string, conn CachedConnection) error {
+	if refresher != nil {
+		expiry := refresher.CredentialsExpiry(conn.db)
+		if !expiry.IsZero() && time.Until(expiry) < 2*c.driverSettings.KeepaliveInterval {
+			if err := refresher.RefreshCredentials(ctx, conn.db); err != nil {
+				return fmt.Errorf("refreshing credentials: %w", err)
+			}
+			return nil
+		}
+	}
+	return c.pingEndpoint(ctx, key, conn)
+}
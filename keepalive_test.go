@@ -0,0 +1,197 @@
+package sqlds
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// expiredConnector fails every connection, as a database that dropped the
+// session would.
+type expiredConnector struct{}
+
+func (expiredConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return nil, errors.New("session expired")
+}
+func (expiredConnector) Driver() driver.Driver { return nil }
+
+// aliveConnector opens connections answering pings.
+type aliveConnector struct{}
+
+func (aliveConnector) Connect(_ context.Context) (driver.Conn, error) { return aliveConn{}, nil }
+func (aliveConnector) Driver() driver.Driver                          { return nil }
+
+type aliveConn struct{}
+
+func (aliveConn) Prepare(_ string) (driver.Stmt, error) { return nil, errors.New("not implemented") }
+func (aliveConn) Close() error                          { return nil }
+func (aliveConn) Begin() (driver.Tx, error)             { return nil, errors.New("not implemented") }
+func (aliveConn) Ping(_ context.Context) error          { return nil }
+
+// hangingConnector opens connections whose pings hang until their context is
+// done, as those to an unreachable host can.
+type hangingConnector struct{}
+
+func (hangingConnector) Connect(_ context.Context) (driver.Conn, error) { return hangingConn{}, nil }
+func (hangingConnector) Driver() driver.Driver                          { return nil }
+
+type hangingConn struct{ aliveConn }
+
+func (hangingConn) Ping(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// keepaliveDriver opens connections failing their pings until healthy is
+// set. refreshingDriver adds a CredentialRefresher reporting expiry.
+type keepaliveDriver struct {
+	noopDriver
+	connects   atomic.Int32
+	healthy    atomic.Bool
+	expiry     time.Time
+	refreshErr error
+	refreshes  atomic.Int32
+}
+
+func (d *keepaliveDriver) Settings(_ context.Context, _ backend.DataSourceInstanceSettings) DriverSettings {
+	return DriverSettings{KeepaliveInterval: 10 * time.Millisecond, DisposeTimeout: time.Second}
+}
+
+func (d *keepaliveDriver) Connect(_ context.Context, _ backend.DataSourceInstanceSettings, _ json.RawMessage) (*sql.DB, error) {
+	d.connects.Add(1)
+	if d.healthy.Load() {
+		return sql.OpenDB(aliveConnector{}), nil
+	}
+	return sql.OpenDB(expiredConnector{}), nil
+}
+
+type refreshingDriver struct {
+	*keepaliveDriver
+}
+
+func (d refreshingDriver) CredentialsExpiry(_ *sql.DB) time.Time { return d.expiry }
+
+func (d refreshingDriver) RefreshCredentials(_ context.Context, _ *sql.DB) error {
+	d.refreshes.Add(1)
+	return d.refreshErr
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before the deadline")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestKeepalive_ReconnectsFailingConnections(t *testing.T) {
+	d := &keepaliveDriver{}
+	conn, err := NewConnector(context.Background(), d, backend.DataSourceInstanceSettings{UID: "keepalive"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Dispose()
+	expired, _ := conn.getDBConnection(conn.defaultKey)
+	d.healthy.Store(true)
+
+	waitFor(t, func() bool { return d.connects.Load() > 1 })
+	waitFor(t, func() bool {
+		current, _ := conn.getDBConnection(conn.defaultKey)
+		return current.db != expired.db
+	})
+	// The replacement is stored before the failing connection is closed.
+	waitFor(t, func() bool { return refClosed(expired) })
+	time.Sleep(30 * time.Millisecond)
+	if got := d.connects.Load(); got != 2 {
+		t.Fatalf("expected healthy connections to be left alone, got %d connects", got)
+	}
+}
+
+// hangingDriver is a keepaliveDriver whose first connection hangs.
+type hangingDriver struct {
+	*keepaliveDriver
+}
+
+func (d hangingDriver) Connect(ctx context.Context, settings backend.DataSourceInstanceSettings, args json.RawMessage) (*sql.DB, error) {
+	if d.connects.Load() == 0 {
+		d.connects.Add(1)
+		return sql.OpenDB(hangingConnector{}), nil
+	}
+	return d.keepaliveDriver.Connect(ctx, settings, args)
+}
+
+func TestKeepalive_TimesOutHangingPings(t *testing.T) {
+	d := &keepaliveDriver{}
+	d.healthy.Store(true)
+	conn, err := NewConnector(context.Background(), hangingDriver{d}, backend.DataSourceInstanceSettings{UID: "keepalive"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Dispose()
+
+	// Without a Timeout, the keepalive interval bounds the ping.
+	waitFor(t, func() bool { return d.connects.Load() > 1 })
+}
+
+func TestKeepalive_RefreshesCredentialsBeforeExpiry(t *testing.T) {
+	d := &keepaliveDriver{expiry: time.Now().Add(time.Millisecond)}
+	d.healthy.Store(true)
+	conn, err := NewConnector(context.Background(), refreshingDriver{d}, backend.DataSourceInstanceSettings{UID: "keepalive"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Dispose()
+
+	waitFor(t, func() bool { return d.refreshes.Load() > 0 })
+	if got := d.connects.Load(); got != 1 {
+		t.Fatalf("expected a successful refresh to keep the connection, got %d connects", got)
+	}
+}
+
+func TestKeepalive_ReconnectsWhenRefreshFails(t *testing.T) {
+	d := &keepaliveDriver{expiry: time.Now().Add(time.Millisecond), refreshErr: errors.New("token exchange failed")}
+	d.healthy.Store(true)
+	conn, err := NewConnector(context.Background(), refreshingDriver{d}, backend.DataSourceInstanceSettings{UID: "keepalive"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Dispose()
+
+	waitFor(t, func() bool { return d.connects.Load() > 1 })
+}
+
+func TestKeepalive_DisposeStopsWorker(t *testing.T) {
+	d := &keepaliveDriver{}
+	conn, err := NewConnector(context.Background(), d, backend.DataSourceInstanceSettings{UID: "keepalive"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Dispose()
+	select {
+	case <-conn.keepalive.done:
+	default:
+		t.Fatal("expected Dispose to stop the keepalive worker")
+	}
+	connects := d.connects.Load()
+	time.Sleep(30 * time.Millisecond)
+	if got := d.connects.Load(); got != connects {
+		t.Fatal("expected no reconnects after Dispose")
+	}
+}
+
+func TestKeepalive_DisabledByDefault(t *testing.T) {
+	conn := newLeaseTestConnector(t)
+	if conn.keepalive != nil {
+		t.Fatal("expected no keepalive worker without KeepaliveInterval")
+	}
+}
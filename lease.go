@@ -0,0 +1,191 @@
+package sqlds
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// defaultDisposeTimeout is how long Connector.Dispose waits for outstanding
+// leases when DriverSettings.DisposeTimeout is not set.
+const defaultDisposeTimeout = 30 * time.Second
+
+// connRef counts the leases on the *sql.DB of a cached connection. It is
+// shared by every copy of the CachedConnection, so closing one copy while
+// another is leased defers the close until the last lease is released.
+type connRef struct {
+	mu      sync.Mutex
+	leases  int
+	retired bool
+	closed  bool
+	// onClosed is called once the *sql.DB is closed.
+	onClosed func()
+}
+
+// acquire takes a lease, unless the connection has been retired.
+func (r *connRef) acquire() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.retired || r.closed {
+		return false
+	}
+	r.leases++
+	return true
+}
+
+// release gives a lease back, reporting whether the connection is retired
+// and this was its last lease, in which case the caller closes it.
+func (r *connRef) release() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.leases--
+	return r.closeLocked(r.retired && r.leases == 0)
+}
+
+// retire stops new leases, reporting whether the connection has none, in
+// which case the caller closes it.
+func (r *connRef) retire() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.retired = true
+	return r.closeLocked(r.leases == 0)
+}
+
+// forceClose reports whether the connection is still open, regardless of its
+// leases, in which case the caller closes it.
+func (r *connRef) forceClose() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.retired = true
+	return r.closeLocked(true)
+}
+
+func (r *connRef) closeLocked(now bool) bool {
+	if !now || r.closed {
+		return false
+	}
+	r.closed = true
+	if r.onClosed != nil {
+		r.onClosed()
+	}
+	return true
+}
+
+// connLease is the lease held by a CachedConnection handed out by the
+// Connector.
+type connLease struct {
+	once    sync.Once
+	release func()
+}
+
+// Release gives back the lease on a connection handed out by
+// Connector.GetConnectionFromQuery. Until every lease on a connection is
+// released, Reconnect, cache eviction and Dispose defer closing it. Release
+// is safe to call more than once, and a no-op on connections that are not
+// leased.
+func (c CachedConnection) Release() {
+	if c.lease != nil {
+		c.lease.once.Do(c.lease.release)
+	}
+}
+
+// newCachedConnection returns a CachedConnection for db, opened with
+// connectionArgs, whose leases the Connector tracks.
+func (c *Connector) newCachedConnection(db *sql.DB, settings backend.DataSourceInstanceSettings, connectionArgs json.RawMessage) CachedConnection {
+	ref := &connRef{}
+	ref.onClosed = func() { c.refs.Delete(ref) }
+	c.refs.Store(ref, db)
+	return CachedConnection{db: db, settings: settings, connectionArgs: connectionArgs, ref: ref}
+}
+
+// lease returns conn holding a lease, or false if conn has been retired.
+// Connections the Connector does not track are returned as they are.
+func (c *Connector) lease(conn CachedConnection) (CachedConnection, bool) {
+	if conn.ref == nil {
+		return conn, true
+	}
+	if !conn.ref.acquire() {
+		return CachedConnection{}, false
+	}
+	c.leaseMu.Lock()
+	c.leases++
+	c.leaseMu.Unlock()
+
+	ref, db := conn.ref, conn.db
+	conn.lease = &connLease{release: func() {
+		if ref.release() {
+			closeRetired(db)
+		}
+		c.leaseMu.Lock()
+		defer c.leaseMu.Unlock()
+		c.leases--
+		if c.leases == 0 && c.leasesReleased != nil {
+			close(c.leasesReleased)
+			c.leasesReleased = nil
+		}
+	}}
+	return conn, true
+}
+
+// leaseDBConnection loads the connection cached under key and leases it. A
+// connection retired between the two steps has already been replaced or
+// dropped, so the load is retried.
+func (c *Connector) leaseDBConnection(key string) (CachedConnection, bool) {
+	for range 3 {
+		conn, ok := c.getDBConnection(key)
+		if !ok {
+			return CachedConnection{}, false
+		}
+		if leased, ok := c.lease(conn); ok {
+			return leased, true
+		}
+	}
+	return CachedConnection{}, false
+}
+
+// waitForLeases waits until every lease has been released or timeout has
+// passed, returning the number of leases still held.
+func (c *Connector) waitForLeases(timeout time.Duration) int {
+	c.leaseMu.Lock()
+	if c.leases == 0 {
+		c.leaseMu.Unlock()
+		return 0
+	}
+	if c.leasesReleased == nil {
+		c.leasesReleased = make(chan struct{})
+	}
+	released := c.leasesReleased
+	c.leaseMu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-released:
+		return 0
+	case <-timer.C:
+		c.leaseMu.Lock()
+		defer c.leaseMu.Unlock()
+		return c.leases
+	}
+}
+
+// closeLeased closes the connections that were still leased when Dispose
+// stopped waiting for them.
+func (c *Connector) closeLeased() {
+	c.refs.Range(func(k, v any) bool {
+		if k.(*connRef).forceClose() {
+			closeRetired(v.(*sql.DB))
+		}
+		return true
+	})
+}
+
+func closeRetired(db *sql.DB) {
+	if err := db.Close(); err != nil {
+		backend.Logger.Warn(fmt.Sprintf("closing retired connection failed: %s", err.Error()))
+	}
+}
@@ -0,0 +1,162 @@
+package sqlds
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+func newLeaseTestConnector(t *testing.T) *Connector {
+	t.Helper()
+	conn, err := NewConnector(context.Background(), noopDriver{}, backend.DataSourceInstanceSettings{UID: "lease"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.driverSettings.DisposeTimeout = 50 * time.Millisecond
+	return conn
+}
+
+func refClosed(c CachedConnection) bool {
+	c.ref.mu.Lock()
+	defer c.ref.mu.Unlock()
+	return c.ref.closed
+}
+
+func TestReconnect_DefersCloseUntilReleased(t *testing.T) {
+	conn := newLeaseTestConnector(t)
+	key, leased, err := conn.GetConnectionFromQuery(context.Background(), &Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := conn.Reconnect(context.Background(), leased, &Query{}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := conn.getDBConnection(key); current.db != db {
+		t.Fatal("expected the new connection to replace the cached one")
+	}
+	if refClosed(leased) {
+		t.Fatal("expected the replaced connection to stay open while leased")
+	}
+
+	leased.Release()
+	if !refClosed(leased) || !dbClosed(leased.db) {
+		t.Fatal("expected the replaced connection to be closed by its last release")
+	}
+	leased.Release()
+}
+
+func TestReconnect_ReusesConcurrentReplacement(t *testing.T) {
+	conn := newLeaseTestConnector(t)
+	key, leased, err := conn.GetConnectionFromQuery(context.Background(), &Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leased.Release()
+
+	var wg sync.WaitGroup
+	dbs := make([]any, 4)
+	for i := range dbs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db, err := conn.Reconnect(context.Background(), leased, &Query{}, key)
+			if err != nil {
+				t.Error(err)
+			}
+			dbs[i] = db
+		}()
+	}
+	wg.Wait()
+
+	current, _ := conn.getDBConnection(key)
+	for _, db := range dbs {
+		if db != any(current.db) {
+			t.Fatal("expected every concurrent Reconnect to return the single replacement")
+		}
+	}
+}
+
+func TestCachedConnection_CloseDefersWhileLeased(t *testing.T) {
+	conn := newLeaseTestConnector(t)
+	_, leased, err := conn.GetConnectionFromQuery(context.Background(), &Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A cache evicting the connection closes its own copy.
+	cached, _ := conn.getDBConnection(conn.defaultKey)
+	if err := cached.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if refClosed(leased) {
+		t.Fatal("expected the evicted connection to stay open while leased")
+	}
+	if _, ok := conn.lease(cached); ok {
+		t.Fatal("expected a closed connection not to be leased again")
+	}
+	leased.Release()
+	if !dbClosed(leased.db) {
+		t.Fatal("expected the evicted connection to be closed by its last release")
+	}
+}
+
+func TestConnectorDispose_WaitsForLeases(t *testing.T) {
+	conn := newLeaseTestConnector(t)
+	conn.driverSettings.DisposeTimeout = time.Minute
+	_, leased, err := conn.GetConnectionFromQuery(context.Background(), &Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(released)
+		leased.Release()
+	}()
+	conn.Dispose()
+	select {
+	case <-released:
+	default:
+		t.Fatal("expected Dispose to wait for the lease to be released")
+	}
+	if !dbClosed(leased.db) {
+		t.Fatal("expected Dispose to close the connection")
+	}
+}
+
+func TestConnectorDispose_ClosesLeasedAfterTimeout(t *testing.T) {
+	conn := newLeaseTestConnector(t)
+	key, leased, err := conn.GetConnectionFromQuery(context.Background(), &Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A connection replaced while leased is closed by Dispose too.
+	if _, err := conn.Reconnect(context.Background(), leased, &Query{}, key); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := swapBackendLogger(t)
+	start := time.Now()
+	conn.Dispose()
+	if elapsed := time.Since(start); elapsed < conn.driverSettings.DisposeTimeout {
+		t.Fatalf("expected Dispose to wait for the timeout, returned after %s", elapsed)
+	}
+	warned := slices.ContainsFunc(rec.entries, func(e recordedLogEntry) bool {
+		return e.level == log.Warn && slices.Equal(e.args, []interface{}{"timeout", conn.driverSettings.DisposeTimeout.String(), "leases", 1})
+	})
+	if !warned {
+		t.Fatalf("expected a warning counting the outstanding lease, got %+v", rec.entries)
+	}
+	if !dbClosed(leased.db) {
+		t.Fatal("expected Dispose to close the connection still leased")
+	}
+	leased.Release()
+}
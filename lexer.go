@@ -0,0 +1,150 @@
+package sqlds
+
+import "strings"
+
+// sqlTokenKind classifies the tokens of SQL text.
+type sqlTokenKind int
+
+const (
+	// sqlSpace is a run of spaces, tabs and line breaks.
+	sqlSpace sqlTokenKind = iota
+	// sqlComment is a line (--) or block (/* */) comment.
+	sqlComment
+	// sqlString is a single-quoted or PostgreSQL dollar-quoted string.
+	sqlString
+	// sqlQuotedIdent is a double-quoted or backtick-quoted identifier.
+	sqlQuotedIdent
+	// sqlNumber is a numeric literal.
+	sqlNumber
+	// sqlParam is a bind parameter such as ?, $1, :1 or @1.
+	sqlParam
+	// sqlWord is a keyword or an unquoted identifier.
+	sqlWord
+	// sqlPunct is any other single byte, such as ; , ( or ).
+	sqlPunct
+)
+
+// sqlToken is a token of SQL text. The tokens lexSQL returns cover the whole
+// text, so concatenating them gives it back.
+type sqlToken struct {
+	kind sqlTokenKind
+	text string
+}
+
+// lexSQL splits sql into tokens. It is the one lexer behind splitting
+// scripts into statements, fingerprinting queries and RedactSQL, so they
+// agree on where strings, identifiers and comments start and end.
+//
+// A doubled quote inside a quoted string or identifier is an escaped quote.
+// With backslashEscapes, a backslash also escapes the byte after it inside
+// single- and double-quoted text, as it does in MySQL by default. Unterminated
+// strings, identifiers and block comments run to the end of sql.
+func lexSQL(sql string, backslashEscapes bool) []sqlToken {
+	var tokens []sqlToken
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		kind, end := sqlPunct, i+1
+		switch {
+		case isSpaceByte(c):
+			kind = sqlSpace
+			for end < len(sql) && isSpaceByte(sql[end]) {
+				end++
+			}
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			kind, end = sqlComment, len(sql)
+			if n := strings.IndexByte(sql[i:], '\n'); n >= 0 {
+				end = i + n
+			}
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			kind, end = sqlComment, len(sql)
+			if n := strings.Index(sql[i+2:], "*/"); n >= 0 {
+				end = i + n + 4
+			}
+		case c == '\'':
+			kind, end = sqlString, quotedEnd(sql, i, backslashEscapes)
+		case c == '"' || c == '`':
+			kind, end = sqlQuotedIdent, quotedEnd(sql, i, backslashEscapes)
+		case c == '$' && dollarQuoteTag(sql[i:]) != "":
+			tag := dollarQuoteTag(sql[i:])
+			kind, end = sqlString, len(sql)
+			if n := strings.Index(sql[i+len(tag):], tag); n >= 0 {
+				end = i + len(tag) + n + len(tag)
+			}
+		case isDigit(c):
+			kind, end = sqlNumber, numberEnd(sql, i+1)
+		case (c == '$' || c == ':' || c == '@') && i+1 < len(sql) && isDigit(sql[i+1]):
+			kind, end = sqlParam, numberEnd(sql, i+1)
+		case c == '?':
+			kind = sqlParam
+		case isIdentByte(c):
+			kind = sqlWord
+			for end < len(sql) && isIdentByte(sql[end]) {
+				end++
+			}
+		}
+		tokens = append(tokens, sqlToken{kind: kind, text: sql[i:end]})
+		i = end
+	}
+	return tokens
+}
+
+// quotedEnd returns the index just past the quoted text starting at
+// sql[start], or len(sql) if it is unterminated.
+func quotedEnd(sql string, start int, backslashEscapes bool) int {
+	quote := sql[start]
+	for i := start + 1; i < len(sql); i++ {
+		switch sql[i] {
+		case '\\':
+			if backslashEscapes && quote != '`' {
+				i++
+			}
+		case quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(sql)
+}
+
+// numberEnd returns the index just past the number, or the digits of the
+// positional parameter, continuing at sql[i].
+func numberEnd(sql string, i int) int {
+	for i < len(sql) && (isIdentByte(sql[i]) || sql[i] == '.') {
+		i++
+	}
+	return i
+}
+
+// dollarQuoteTag returns the opening $tag$ of a PostgreSQL dollar-quoted
+// string at the start of s, or "" if s does not start with one. Positional
+// parameters such as $1 are not dollar quotes, since a tag cannot start with
+// a digit.
+func dollarQuoteTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '$':
+			return s[:i+1]
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 1:
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+func isSpaceByte(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
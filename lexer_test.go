@@ -0,0 +1,42 @@
+package sqlds
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLexSQL(t *testing.T) {
+	sql := "SELECT \"a\"\"b\", 'it''s', $1, :2, ?, 1.5e3 -- c\nFROM t2 /* x */ WHERE v = $q$'$q$;"
+	want := []sqlToken{
+		{sqlWord, "SELECT"}, {sqlSpace, " "}, {sqlQuotedIdent, `"a""b"`}, {sqlPunct, ","}, {sqlSpace, " "},
+		{sqlString, "'it''s'"}, {sqlPunct, ","}, {sqlSpace, " "}, {sqlParam, "$1"}, {sqlPunct, ","}, {sqlSpace, " "},
+		{sqlParam, ":2"}, {sqlPunct, ","}, {sqlSpace, " "}, {sqlParam, "?"}, {sqlPunct, ","}, {sqlSpace, " "},
+		{sqlNumber, "1.5e3"}, {sqlSpace, " "}, {sqlComment, "-- c"}, {sqlSpace, "\n"}, {sqlWord, "FROM"}, {sqlSpace, " "},
+		{sqlWord, "t2"}, {sqlSpace, " "}, {sqlComment, "/* x */"}, {sqlSpace, " "}, {sqlWord, "WHERE"}, {sqlSpace, " "},
+		{sqlWord, "v"}, {sqlSpace, " "}, {sqlPunct, "="}, {sqlSpace, " "}, {sqlString, "$q$'$q$"}, {sqlPunct, ";"},
+	}
+	got := lexSQL(sql, false)
+	if len(got) != len(want) {
+		t.Fatalf("got %d tokens %v, want %d", len(got), got, len(want))
+	}
+	var text strings.Builder
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("token %d: got %+v, want %+v", i, got[i], want[i])
+		}
+		text.WriteString(got[i].text)
+	}
+	if text.String() != sql {
+		t.Fatalf("expected the tokens to cover the text, got %q", text.String())
+	}
+}
+
+func TestLexSQL_BackslashEscapes(t *testing.T) {
+	sql := `'a\'b' x`
+	if got := lexSQL(sql, true)[0]; got != (sqlToken{sqlString, `'a\'b'`}) {
+		t.Fatalf("expected the escaped quote to stay in the string, got %+v", got)
+	}
+	if got := lexSQL(sql, false)[0]; got != (sqlToken{sqlString, `'a\'`}) {
+		t.Fatalf("expected the backslash to be a plain character, got %+v", got)
+	}
+}
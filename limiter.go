@@ -0,0 +1,48 @@
+package sqlds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"golang.org/x/sync/semaphore"
+)
+
+// ErrorQueryQueueTimeout is returned when a query is still waiting for a free slot under
+// DriverSettings.MaxConcurrentQueries when its context is done
+var ErrorQueryQueueTimeout = errors.New("query timed out waiting for a free query slot")
+
+// queryLimiter bounds how many queries a datasource instance runs against the
+// database at once. One limiter is shared by every QueryData and RunStream
+// call on the instance. A nil *queryLimiter imposes no limit.
+type queryLimiter struct {
+	sem     *semaphore.Weighted
+	metrics Metrics
+}
+
+// newQueryLimiter returns a limiter admitting max concurrent queries, or nil
+// when max is not positive.
+func newQueryLimiter(max int64, metrics Metrics) *queryLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &queryLimiter{sem: semaphore.NewWeighted(max), metrics: metrics}
+}
+
+// acquire blocks until a query slot is free or ctx is done, and records the
+// time spent waiting. On success the returned func releases the slot and
+// must be called exactly once.
+func (l *queryLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	start := time.Now()
+	err := l.sem.Acquire(ctx, 1)
+	l.metrics.CollectQueueWait(err == nil, time.Since(start).Seconds())
+	if err != nil {
+		return nil, backend.DownstreamError(fmt.Errorf("%w: %w", ErrorQueryQueueTimeout, err))
+	}
+	return func() { l.sem.Release(1) }, nil
+}
@@ -0,0 +1,75 @@
+package sqlds
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestQueryLimiter_NilIsUnlimited(t *testing.T) {
+	if l := newQueryLimiter(0, Metrics{}); l != nil {
+		t.Fatal("expected no limiter when the limit is unset")
+	}
+	var l *queryLimiter
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestQueryLimiter_BlocksUntilReleased(t *testing.T) {
+	l := newQueryLimiter(1, NewMetrics("limiter", "test", EndpointQuery))
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		second, err := l.acquire(context.Background())
+		if err != nil {
+			t.Errorf("unexpected error %v", err)
+			return
+		}
+		second()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("second query ran while the only slot was held")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("second query did not run after the slot was released")
+	}
+}
+
+func TestQueryLimiter_DeadlineWhileQueued(t *testing.T) {
+	l := newQueryLimiter(1, NewMetrics("limiter", "test", EndpointQuery))
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = l.acquire(ctx)
+	if !errors.Is(err, ErrorQueryQueueTimeout) {
+		t.Fatalf("expected ErrorQueryQueueTimeout, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the context error to be wrapped, got %v", err)
+	}
+	if !backend.IsDownstreamError(err) {
+		t.Fatal("expected a downstream error")
+	}
+}
@@ -0,0 +1,112 @@
+package sqlds
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// queryLimitOverrides are the optional fields of a query's JSON that override
+// the datasource timeout and row limit for that query.
+type queryLimitOverrides struct {
+	// Timeout is a duration string such as "30s" or "5m", or a number of
+	// seconds.
+	Timeout json.RawMessage `json:"timeout"`
+	// RowLimit can only lower the datasource row limit.
+	RowLimit int64 `json:"rowLimit"`
+}
+
+// queryLimits are the timeout and row limit a single query runs with. A zero
+// timeout means no timeout and a negative row limit means no row limit.
+type queryLimits struct {
+	timeout  time.Duration
+	rowLimit int64
+}
+
+// resolveQueryLimits applies the overrides in a query's JSON to the
+// datasource timeout and row limit, clamping them to the maximums in
+// settings. The overrides are only read when
+// DriverSettings.QueryLimitOverrides is set. When DriverSettings.MaxTimeout is
+// not set, settings.Timeout is the maximum, so a query can shorten the timeout
+// but not extend it. Likewise the datasource row limit, itself capped by
+// DriverSettings.MaxRowLimit, bounds the row limit a query can ask for.
+// Malformed overrides are logged and ignored.
+func (ds *SQLDatasource) resolveQueryLimits(settings DriverSettings, raw json.RawMessage) queryLimits {
+	limits := queryLimits{
+		timeout:  clampTimeout(settings.Timeout, settings.MaxTimeout),
+		rowLimit: clampRowLimit(ds.rowLimit, settings.MaxRowLimit),
+	}
+	if !settings.QueryLimitOverrides || len(raw) == 0 {
+		return limits
+	}
+
+	var overrides queryLimitOverrides
+	if err := json.Unmarshal(raw, &overrides); err != nil {
+		backend.Logger.Warn(fmt.Sprintf("ignoring the query timeout and row limit: %s", err.Error()))
+		return limits
+	}
+
+	if timeout, err := parseQueryTimeout(overrides.Timeout); err != nil {
+		backend.Logger.Warn(fmt.Sprintf("ignoring the query timeout: %s", err.Error()))
+	} else if timeout > 0 {
+		maxTimeout := settings.MaxTimeout
+		if maxTimeout <= 0 {
+			maxTimeout = settings.Timeout
+		}
+		limits.timeout = clampTimeout(timeout, maxTimeout)
+	}
+
+	if overrides.RowLimit < 0 {
+		backend.Logger.Warn(fmt.Sprintf("ignoring the negative query row limit %d", overrides.RowLimit))
+	} else if overrides.RowLimit > 0 {
+		limits.rowLimit = clampRowLimit(overrides.RowLimit, limits.rowLimit)
+	}
+	return limits
+}
+
+// parseQueryTimeout parses the timeout of a query's JSON, either a duration
+// string or a number of seconds. A missing timeout is returned as zero.
+func parseQueryTimeout(raw json.RawMessage) (time.Duration, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return 0, nil
+	}
+
+	var timeout time.Duration
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		if timeout, err = time.ParseDuration(s); err != nil {
+			return 0, err
+		}
+	} else {
+		var seconds float64
+		if err := json.Unmarshal(raw, &seconds); err != nil {
+			return 0, fmt.Errorf("timeout must be a duration or a number of seconds, got %s", raw)
+		}
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+
+	if timeout < 0 {
+		return 0, fmt.Errorf("negative timeout %s", timeout)
+	}
+	return timeout, nil
+}
+
+// clampTimeout returns timeout bounded by max. A zero timeout stays
+// unbounded, as does any timeout when max is zero.
+func clampTimeout(timeout, max time.Duration) time.Duration {
+	if max > 0 && timeout > max {
+		return max
+	}
+	return timeout
+}
+
+// clampRowLimit returns limit bounded by max. A negative limit means no
+// limit, as does a max that is not positive.
+func clampRowLimit(limit, max int64) int64 {
+	if max > 0 && (limit < 0 || limit > max) {
+		return max
+	}
+	return limit
+}
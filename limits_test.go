@@ -0,0 +1,133 @@
+package sqlds
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveQueryLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings DriverSettings
+		rowLimit int64
+		json     string
+		want     queryLimits
+	}{
+		{
+			name:     "no overrides",
+			settings: DriverSettings{QueryLimitOverrides: true, Timeout: 10 * time.Second},
+			rowLimit: 100,
+			json:     `{"rawSql": "SELECT 1"}`,
+			want:     queryLimits{timeout: 10 * time.Second, rowLimit: 100},
+		},
+		{
+			name:     "shorter timeout and row limit",
+			settings: DriverSettings{QueryLimitOverrides: true, Timeout: 10 * time.Second},
+			rowLimit: 100,
+			json:     `{"timeout": "2s", "rowLimit": 10}`,
+			want:     queryLimits{timeout: 2 * time.Second, rowLimit: 10},
+		},
+		{
+			name:     "timeout in seconds",
+			settings: DriverSettings{QueryLimitOverrides: true, Timeout: 10 * time.Second},
+			rowLimit: -1,
+			json:     `{"timeout": 1.5}`,
+			want:     queryLimits{timeout: 1500 * time.Millisecond, rowLimit: -1},
+		},
+		{
+			name:     "longer timeout within the maximum",
+			settings: DriverSettings{QueryLimitOverrides: true, Timeout: 10 * time.Second, MaxTimeout: 5 * time.Minute},
+			rowLimit: -1,
+			json:     `{"timeout": "5m"}`,
+			want:     queryLimits{timeout: 5 * time.Minute, rowLimit: -1},
+		},
+		{
+			name:     "timeout clamped to the maximum",
+			settings: DriverSettings{QueryLimitOverrides: true, Timeout: 10 * time.Second, MaxTimeout: time.Minute},
+			rowLimit: -1,
+			json:     `{"timeout": "1h"}`,
+			want:     queryLimits{timeout: time.Minute, rowLimit: -1},
+		},
+		{
+			name:     "timeout clamped to the default without a maximum",
+			settings: DriverSettings{QueryLimitOverrides: true, Timeout: 10 * time.Second},
+			rowLimit: -1,
+			json:     `{"timeout": "1h"}`,
+			want:     queryLimits{timeout: 10 * time.Second, rowLimit: -1},
+		},
+		{
+			name:     "timeout unbounded without a default or maximum",
+			settings: DriverSettings{QueryLimitOverrides: true},
+			rowLimit: -1,
+			json:     `{"timeout": "1h"}`,
+			want:     queryLimits{timeout: time.Hour, rowLimit: -1},
+		},
+		{
+			name:     "maximum applies without overrides",
+			settings: DriverSettings{Timeout: time.Hour, MaxTimeout: time.Minute, MaxRowLimit: 50},
+			rowLimit: -1,
+			json:     `{}`,
+			want:     queryLimits{timeout: time.Minute, rowLimit: 50},
+		},
+		{
+			name:     "no timeout stays unbounded under a maximum",
+			settings: DriverSettings{MaxTimeout: time.Minute},
+			rowLimit: -1,
+			json:     `{}`,
+			want:     queryLimits{rowLimit: -1},
+		},
+		{
+			name:     "timeout clamped to the maximum without a default",
+			settings: DriverSettings{QueryLimitOverrides: true, MaxTimeout: time.Minute},
+			rowLimit: -1,
+			json:     `{"timeout": "1h"}`,
+			want:     queryLimits{timeout: time.Minute, rowLimit: -1},
+		},
+		{
+			name:     "overrides ignored unless enabled",
+			settings: DriverSettings{Timeout: 10 * time.Second},
+			rowLimit: 100,
+			json:     `{"timeout": "2s", "rowLimit": 10}`,
+			want:     queryLimits{timeout: 10 * time.Second, rowLimit: 100},
+		},
+		{
+			name:     "row limit cannot be raised",
+			settings: DriverSettings{QueryLimitOverrides: true},
+			rowLimit: 100,
+			json:     `{"rowLimit": 1000}`,
+			want:     queryLimits{rowLimit: 100},
+		},
+		{
+			name:     "row limit clamped to the maximum",
+			settings: DriverSettings{QueryLimitOverrides: true, MaxRowLimit: 50},
+			rowLimit: -1,
+			json:     `{"rowLimit": 1000}`,
+			want:     queryLimits{rowLimit: 50},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &SQLDatasource{rowLimit: tt.rowLimit}
+			if got := ds.resolveQueryLimits(tt.settings, []byte(tt.json)); got != tt.want {
+				t.Fatalf("got %+v want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveQueryLimits_Invalid(t *testing.T) {
+	settings := DriverSettings{QueryLimitOverrides: true, Timeout: 10 * time.Second}
+	want := queryLimits{timeout: 10 * time.Second, rowLimit: 100}
+	for _, json := range []string{
+		`{"timeout": "soon"}`,
+		`{"timeout": "-1s"}`,
+		`{"timeout": true}`,
+		`{"rowLimit": -5}`,
+		`{"rowLimit": "ten"}`,
+	} {
+		ds := &SQLDatasource{rowLimit: 100}
+		if got := ds.resolveQueryLimits(settings, []byte(json)); got != want {
+			t.Fatalf("%s: expected the override to be ignored, got %+v", json, got)
+		}
+	}
+}
@@ -1,19 +1,27 @@
 package sqlds
 
 import (
+	"context"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/grafana/sqlds/v5/responseobs"
 )
 
 type Metrics struct {
 	DSName   string
 	DSType   string
 	Endpoint Endpoint
+	// provider holds the collectors to record to. Nil means the
+	// package-level collectors.
+	provider *MetricsProvider
 }
 
 type Status string
@@ -29,31 +37,218 @@ const (
 	SourcePlugin     Source   = "plugin"
 )
 
-var durationMetric = promauto.NewHistogramVec(prometheus.HistogramOpts{
-	Namespace: "plugins",
-	Name:      "plugin_request_duration_seconds",
-	Help:      "Duration of plugin execution",
-}, []string{"datasource_name", "datasource_type", "source", "endpoint", "status"})
-
-var responseRowsMetric = promauto.NewHistogramVec(prometheus.HistogramOpts{
-	Namespace:                       "plugins",
-	Name:                            "sql_response_rows",
-	Help:                            "Number of rows returned by a SQL datasource query",
-	Buckets:                         []float64{1, 10, 100, 1_000, 10_000, 100_000, 1_000_000, 10_000_000, 100_000_000},
-	NativeHistogramBucketFactor:     1.1,
-	NativeHistogramMaxBucketNumber:  100,
-	NativeHistogramMinResetDuration: time.Hour,
-}, []string{"datasource_type"})
-
-var responseCellsMetric = promauto.NewHistogramVec(prometheus.HistogramOpts{
-	Namespace:                       "plugins",
-	Name:                            "sql_response_cells",
-	Help:                            "Number of cells (rows × fields) returned by a SQL datasource query",
-	Buckets:                         []float64{1, 100, 10_000, 1_000_000, 10_000_000, 100_000_000, 1_000_000_000, 10_000_000_000},
-	NativeHistogramBucketFactor:     1.1,
-	NativeHistogramMaxBucketNumber:  100,
-	NativeHistogramMinResetDuration: time.Hour,
-}, []string{"datasource_type"})
+var (
+	durationOpts = prometheus.HistogramOpts{
+		Namespace: "plugins",
+		Name:      "plugin_request_duration_seconds",
+		Help:      "Duration of plugin execution",
+	}
+	durationLabels = []string{"datasource_name", "datasource_type", "source", "endpoint", "status"}
+
+	responseRowsOpts = prometheus.HistogramOpts{
+		Namespace:                       "plugins",
+		Name:                            "sql_response_rows",
+		Help:                            "Number of rows returned by a SQL datasource query",
+		Buckets:                         []float64{1, 10, 100, 1_000, 10_000, 100_000, 1_000_000, 10_000_000, 100_000_000},
+		NativeHistogramBucketFactor:     1.1,
+		NativeHistogramMaxBucketNumber:  100,
+		NativeHistogramMinResetDuration: time.Hour,
+	}
+	responseCellsOpts = prometheus.HistogramOpts{
+		Namespace:                       "plugins",
+		Name:                            "sql_response_cells",
+		Help:                            "Number of cells (rows × fields) returned by a SQL datasource query",
+		Buckets:                         []float64{1, 100, 10_000, 1_000_000, 10_000_000, 100_000_000, 1_000_000_000, 10_000_000_000},
+		NativeHistogramBucketFactor:     1.1,
+		NativeHistogramMaxBucketNumber:  100,
+		NativeHistogramMinResetDuration: time.Hour,
+	}
+	responseBytesOpts = prometheus.HistogramOpts{
+		Namespace:                       "plugins",
+		Name:                            "sql_response_bytes",
+		Help:                            "Serialized size in bytes of the frames returned by a SQL datasource query, estimated unless measured exactly",
+		Buckets:                         []float64{1_000, 10_000, 100_000, 1_000_000, 10_000_000, 100_000_000, 1_000_000_000},
+		NativeHistogramBucketFactor:     1.1,
+		NativeHistogramMaxBucketNumber:  100,
+		NativeHistogramMinResetDuration: time.Hour,
+	}
+	responseLabels = []string{"datasource_type"}
+
+	resultCacheOpts = prometheus.CounterOpts{
+		Namespace: "plugins",
+		Name:      "sql_result_cache_requests_total",
+		Help:      "Number of SQL datasource result cache lookups, by result (hit or miss)",
+	}
+	resultCacheLabels = []string{"datasource_name", "datasource_type", "result"}
+
+	queueWaitOpts = prometheus.HistogramOpts{
+		Namespace: "plugins",
+		Name:      "sql_query_queue_wait_seconds",
+		Help:      "Time a SQL datasource query waited for a free slot under the concurrent query limit",
+		Buckets:   []float64{0.001, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60},
+	}
+	queueWaitLabels = []string{"datasource_name", "datasource_type", "status"}
+
+	retryOpts = prometheus.CounterOpts{
+		Namespace: "plugins",
+		Name:      "sql_retry_attempts_total",
+		Help:      "Number of retries of failed SQL datasource queries and connection attempts, by reason (error, timeout or connect) and attempt number",
+	}
+	retryLabels = []string{"datasource_name", "datasource_type", "endpoint", "reason", "attempt"}
+
+	interpolationOpts = prometheus.HistogramOpts{
+		Namespace: "plugins",
+		Name:      "sql_interpolation_duration_seconds",
+		Help:      "Time spent applying macros to SQL datasource queries",
+		Buckets:   []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1},
+	}
+	connectionAcquireOpts = prometheus.HistogramOpts{
+		Namespace: "plugins",
+		Name:      "sql_connection_acquire_duration_seconds",
+		Help:      "Time spent getting the connection of a SQL datasource query, by result (hit, new or error)",
+		Buckets:   []float64{0.0001, 0.001, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30},
+	}
+	executionOpts = prometheus.HistogramOpts{
+		Namespace: "plugins",
+		Name:      "sql_query_execution_duration_seconds",
+		Help:      "Time the database took to start returning the rows of a SQL datasource query",
+		Buckets:   []float64{0.001, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300},
+	}
+	frameConversionOpts = prometheus.HistogramOpts{
+		Namespace: "plugins",
+		Name:      "sql_frame_conversion_duration_seconds",
+		Help:      "Time spent reading the rows of a SQL datasource query into data frames",
+		Buckets:   []float64{0.001, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60},
+	}
+	stageLabels             = []string{"datasource_name", "datasource_type", "status"}
+	connectionAcquireLabels = []string{"datasource_name", "datasource_type", "result"}
+
+	reconnectsOpts = prometheus.CounterOpts{
+		Namespace: "plugins",
+		Name:      "sql_reconnects_total",
+		Help:      "Number of reconnects of SQL datasource connections, by status",
+	}
+	reconnectsLabels = []string{"datasource_name", "datasource_type", "status"}
+
+	inFlightOpts = prometheus.GaugeOpts{
+		Namespace: "plugins",
+		Name:      "sql_queries_in_flight",
+		Help:      "Number of SQL datasource queries running against the database",
+	}
+	inFlightLabels = []string{"datasource_name", "datasource_type"}
+)
+
+var durationMetric = promauto.NewHistogramVec(durationOpts, durationLabels)
+
+var responseRowsMetric = promauto.NewHistogramVec(responseRowsOpts, responseLabels)
+
+var responseCellsMetric = promauto.NewHistogramVec(responseCellsOpts, responseLabels)
+
+var responseBytesMetric = promauto.NewHistogramVec(responseBytesOpts, responseLabels)
+
+var resultCacheMetric = promauto.NewCounterVec(resultCacheOpts, resultCacheLabels)
+
+var queueWaitMetric = promauto.NewHistogramVec(queueWaitOpts, queueWaitLabels)
+
+var retryMetric = promauto.NewCounterVec(retryOpts, retryLabels)
+
+var interpolationMetric = promauto.NewHistogramVec(interpolationOpts, stageLabels)
+
+var connectionAcquireMetric = promauto.NewHistogramVec(connectionAcquireOpts, connectionAcquireLabels)
+
+var executionMetric = promauto.NewHistogramVec(executionOpts, stageLabels)
+
+var frameConversionMetric = promauto.NewHistogramVec(frameConversionOpts, stageLabels)
+
+var reconnectsMetric = promauto.NewCounterVec(reconnectsOpts, reconnectsLabels)
+
+var inFlightMetric = promauto.NewGaugeVec(inFlightOpts, inFlightLabels)
+
+var dbStatsMetric = newDBStatsCollector(prometheus.DefaultRegisterer)
+
+// MetricsProvider is a set of the Prometheus collectors sqlds records to.
+// Without one, sqlds records to package-level collectors registered on the
+// default Prometheus registry. A provider lets a plugin serving several
+// datasources, or a test, keep their metrics apart on registries of its own.
+type MetricsProvider struct {
+	duration      *prometheus.HistogramVec
+	responseRows  *prometheus.HistogramVec
+	responseCells *prometheus.HistogramVec
+	responseBytes *prometheus.HistogramVec
+	resultCache   *prometheus.CounterVec
+	queueWait     *prometheus.HistogramVec
+	retry         *prometheus.CounterVec
+
+	interpolation     *prometheus.HistogramVec
+	connectionAcquire *prometheus.HistogramVec
+	execution         *prometheus.HistogramVec
+	frameConversion   *prometheus.HistogramVec
+	reconnects        *prometheus.CounterVec
+	inFlight          *prometheus.GaugeVec
+	dbStats           *dbStatsCollector
+
+	// Responses receives the observations of query responses. Register
+	// responseobs.Observers on it to see the responses of datasources using
+	// this provider.
+	Responses *responseobs.Registry
+}
+
+// defaultMetricsProvider holds the package-level collectors.
+var defaultMetricsProvider = &MetricsProvider{
+	duration:      durationMetric,
+	responseRows:  responseRowsMetric,
+	responseCells: responseCellsMetric,
+	responseBytes: responseBytesMetric,
+	resultCache:   resultCacheMetric,
+	queueWait:     queueWaitMetric,
+	retry:         retryMetric,
+
+	interpolation:     interpolationMetric,
+	connectionAcquire: connectionAcquireMetric,
+	execution:         executionMetric,
+	frameConversion:   frameConversionMetric,
+	reconnects:        reconnectsMetric,
+	inFlight:          inFlightMetric,
+	dbStats:           dbStatsMetric,
+
+	Responses: responseobs.DefaultRegistry,
+}
+
+// NewMetricsProvider returns a MetricsProvider whose collectors, including
+// the responseobs large-response counter, are registered on reg. Like
+// promauto, it panics if reg already holds them, so a registry takes a
+// single provider.
+func NewMetricsProvider(reg prometheus.Registerer) *MetricsProvider {
+	factory := promauto.With(reg)
+	return &MetricsProvider{
+		duration:      factory.NewHistogramVec(durationOpts, durationLabels),
+		responseRows:  factory.NewHistogramVec(responseRowsOpts, responseLabels),
+		responseCells: factory.NewHistogramVec(responseCellsOpts, responseLabels),
+		responseBytes: factory.NewHistogramVec(responseBytesOpts, responseLabels),
+		resultCache:   factory.NewCounterVec(resultCacheOpts, resultCacheLabels),
+		queueWait:     factory.NewHistogramVec(queueWaitOpts, queueWaitLabels),
+		retry:         factory.NewCounterVec(retryOpts, retryLabels),
+
+		interpolation:     factory.NewHistogramVec(interpolationOpts, stageLabels),
+		connectionAcquire: factory.NewHistogramVec(connectionAcquireOpts, connectionAcquireLabels),
+		execution:         factory.NewHistogramVec(executionOpts, stageLabels),
+		frameConversion:   factory.NewHistogramVec(frameConversionOpts, stageLabels),
+		reconnects:        factory.NewCounterVec(reconnectsOpts, reconnectsLabels),
+		inFlight:          factory.NewGaugeVec(inFlightOpts, inFlightLabels),
+		dbStats:           newDBStatsCollector(reg),
+
+		Responses: responseobs.NewRegistryFor(reg),
+	}
+}
+
+// NewMetrics returns Metrics recording to the collectors of p. A nil p
+// records to the package-level collectors, like the package-level
+// NewMetrics.
+func (p *MetricsProvider) NewMetrics(dsName, dsType string, endpoint Endpoint) Metrics {
+	m := NewMetrics(dsName, dsType, endpoint)
+	m.provider = p
+	return m
+}
 
 func NewMetrics(dsName, dsType string, endpoint Endpoint) Metrics {
 	dsName, ok := sanitizeLabelName(dsName)
@@ -64,16 +259,130 @@ func NewMetrics(dsName, dsType string, endpoint Endpoint) Metrics {
 }
 
 func (m *Metrics) WithEndpoint(endpoint Endpoint) Metrics {
-	return Metrics{DSName: m.DSName, DSType: m.DSType, Endpoint: endpoint}
+	return Metrics{DSName: m.DSName, DSType: m.DSType, Endpoint: endpoint, provider: m.provider}
+}
+
+func (m *Metrics) collectors() *MetricsProvider {
+	if m.provider == nil {
+		return defaultMetricsProvider
+	}
+	return m.provider
 }
 
 func (m *Metrics) CollectDuration(source Source, status Status, duration float64) {
-	durationMetric.WithLabelValues(m.DSName, m.DSType, string(source), string(m.Endpoint), string(status)).Observe(duration)
+	m.collectors().duration.WithLabelValues(m.DSName, m.DSType, string(source), string(m.Endpoint), string(status)).Observe(duration)
 }
 
 func (m *Metrics) CollectResponseSize(rows, cells int64) {
-	responseRowsMetric.WithLabelValues(m.DSType).Observe(float64(rows))
-	responseCellsMetric.WithLabelValues(m.DSType).Observe(float64(cells))
+	m.collectors().responseRows.WithLabelValues(m.DSType).Observe(float64(rows))
+	m.collectors().responseCells.WithLabelValues(m.DSType).Observe(float64(cells))
+}
+
+func (m *Metrics) CollectResponseBytes(bytes int64) {
+	m.collectors().responseBytes.WithLabelValues(m.DSType).Observe(float64(bytes))
+}
+
+func (m *Metrics) CollectResultCache(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.collectors().resultCache.WithLabelValues(m.DSName, m.DSType, result).Inc()
+}
+
+// CollectQueueWait records how long a query waited for a slot under the
+// concurrent query limit. acquired is false when the query gave up waiting.
+func (m *Metrics) CollectQueueWait(acquired bool, duration float64) {
+	status := StatusOK
+	if !acquired {
+		status = StatusError
+	}
+	m.collectors().queueWait.WithLabelValues(m.DSName, m.DSType, string(status)).Observe(duration)
+}
+
+// RetryReason is why a query or connection attempt was retried.
+type RetryReason string
+
+const (
+	RetryReasonError   RetryReason = "error"
+	RetryReasonTimeout RetryReason = "timeout"
+	RetryReasonConnect RetryReason = "connect"
+)
+
+// CollectRetry records retry number attempt, starting at 1, for reason.
+func (m *Metrics) CollectRetry(ctx context.Context, reason RetryReason, attempt int) {
+	addWithExemplar(ctx, m.collectors().retry.WithLabelValues(m.DSName, m.DSType, string(m.Endpoint), string(reason), strconv.Itoa(attempt)))
+}
+
+// CollectInterpolation records how long applying macros to a query took.
+func (m *Metrics) CollectInterpolation(ctx context.Context, status Status, duration float64) {
+	observeWithExemplar(ctx, m.collectors().interpolation.WithLabelValues(m.DSName, m.DSType, string(status)), duration)
+}
+
+// CollectConnectionAcquire records how long getting the connection of a
+// query took. result is "hit" for a cached connection, "new" for one that
+// was opened for the query and "error" when none could be had.
+func (m *Metrics) CollectConnectionAcquire(ctx context.Context, result string, duration float64) {
+	observeWithExemplar(ctx, m.collectors().connectionAcquire.WithLabelValues(m.DSName, m.DSType, result), duration)
+}
+
+// CollectExecution records how long the database took to answer a query
+// with rows, not counting the time spent reading them.
+func (m *Metrics) CollectExecution(ctx context.Context, status Status, duration float64) {
+	observeWithExemplar(ctx, m.collectors().execution.WithLabelValues(m.DSName, m.DSType, string(status)), duration)
+}
+
+// CollectFrameConversion records how long reading the rows of a query into
+// frames took.
+func (m *Metrics) CollectFrameConversion(ctx context.Context, status Status, duration float64) {
+	observeWithExemplar(ctx, m.collectors().frameConversion.WithLabelValues(m.DSName, m.DSType, string(status)), duration)
+}
+
+// CollectReconnect records a reconnect, failed when err is not nil.
+func (m *Metrics) CollectReconnect(ctx context.Context, err error) {
+	status := StatusOK
+	if err != nil {
+		status = StatusError
+	}
+	addWithExemplar(ctx, m.collectors().reconnects.WithLabelValues(m.DSName, m.DSType, string(status)))
+}
+
+// TrackInFlight counts a query as running against the database until the
+// returned func is called.
+func (m *Metrics) TrackInFlight() (done func()) {
+	gauge := m.collectors().inFlight.WithLabelValues(m.DSName, m.DSType)
+	gauge.Inc()
+	return gauge.Dec
+}
+
+// exemplarLabels returns the trace ID of the sampled span in ctx as exemplar
+// labels, or nil when there is none.
+func exemplarLabels(ctx context.Context) prometheus.Labels {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() || !sc.IsSampled() {
+		return nil
+	}
+	return prometheus.Labels{"trace_id": sc.TraceID().String()}
+}
+
+func observeWithExemplar(ctx context.Context, obs prometheus.Observer, v float64) {
+	if labels := exemplarLabels(ctx); labels != nil {
+		if eo, ok := obs.(prometheus.ExemplarObserver); ok {
+			eo.ObserveWithExemplar(v, labels)
+			return
+		}
+	}
+	obs.Observe(v)
+}
+
+func addWithExemplar(ctx context.Context, c prometheus.Counter) {
+	if labels := exemplarLabels(ctx); labels != nil {
+		if ea, ok := c.(prometheus.ExemplarAdder); ok {
+			ea.AddWithExemplar(1, labels)
+			return
+		}
+	}
+	c.Inc()
 }
 
 // sanitizeLabelName removes all invalid chars from the label name.
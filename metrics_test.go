@@ -0,0 +1,206 @@
+package sqlds_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/sqlds/v5"
+	"github.com/grafana/sqlds/v5/responseobs"
+	"github.com/grafana/sqlds/v5/test"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsProvider_RecordsToItsRegistry(t *testing.T) {
+	rows := test.Data{
+		Cols: []test.Column{{Name: "v", DataType: "INTEGER", Kind: int64(0)}},
+		Rows: [][]any{{int64(1)}, {int64(2)}},
+	}
+	name := "metrics_provider"
+	driver, _ := test.NewDriver(name, rows, nil, test.DriverOpts{}, nil)
+	ds := sqlds.NewDatasource(driver)
+	reg := prometheus.NewRegistry()
+	ds.MetricsProvider = sqlds.NewMetricsProvider(reg)
+
+	var observed, observedDefault int
+	unregister := ds.MetricsProvider.Responses.Register(responseobs.ObserverFunc(func(context.Context, responseobs.Observation, bool) {
+		observed++
+	}))
+	defer unregister()
+	unregisterDefault := responseobs.Register(responseobs.ObserverFunc(func(_ context.Context, obs responseobs.Observation, _ bool) {
+		if obs.Datasource.UID == name {
+			observedDefault++
+		}
+	}))
+	defer unregisterDefault()
+
+	req, settings := setupQueryRequest(name, "{}")
+	settings.Name = name
+	req.PluginContext.DataSourceInstanceSettings = &settings
+	_, err := ds.NewDatasource(context.Background(), settings)
+	require.NoError(t, err)
+
+	res, err := ds.QueryData(context.Background(), req)
+	require.NoError(t, err)
+	require.NoError(t, res.Responses["foo"].Error)
+
+	assert.Equal(t, 1, observed, "expected the provider's responseobs registry to see the response")
+	assert.Zero(t, observedDefault, "expected the default responseobs registry not to see the response")
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	assert.True(t, hasSeries(families, "plugins_plugin_request_duration_seconds", "datasource_name", name))
+	assert.NotNil(t, findFamily(families, "plugins_sql_response_rows"))
+
+	defaults, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	assert.False(t, hasSeries(defaults, "plugins_plugin_request_duration_seconds", "datasource_name", name),
+		"expected no series on the default registry")
+}
+
+func TestMetricsProvider_NilRecordsToDefaults(t *testing.T) {
+	var p *sqlds.MetricsProvider
+	m := p.NewMetrics("metrics_provider_nil", "nil-type", sqlds.EndpointQuery)
+	m.CollectDuration(sqlds.SourceDownstream, sqlds.StatusOK, 1)
+
+	defaults, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	assert.True(t, hasSeries(defaults, "plugins_plugin_request_duration_seconds", "datasource_name", "metrics_provider_nil"))
+}
+
+func TestStageMetrics(t *testing.T) {
+	recordSpans(t)
+
+	rows := test.Data{
+		Cols: []test.Column{{Name: "v", DataType: "INTEGER", Kind: int64(0)}},
+		Rows: [][]any{{int64(1)}},
+	}
+	name := "stage_metrics"
+	driver, _ := test.NewDriver(name, rows, nil, test.DriverOpts{}, nil)
+	ds := sqlds.NewDatasource(driver)
+	reg := prometheus.NewRegistry()
+	ds.MetricsProvider = sqlds.NewMetricsProvider(reg)
+
+	req, settings := setupQueryRequest(name, "{}")
+	_, err := ds.NewDatasource(context.Background(), settings)
+	require.NoError(t, err)
+	res, err := ds.QueryData(context.Background(), req)
+	require.NoError(t, err)
+	require.NoError(t, res.Responses["foo"].Error)
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, name := range []string{
+		"plugins_sql_interpolation_duration_seconds",
+		"plugins_sql_query_execution_duration_seconds",
+		"plugins_sql_frame_conversion_duration_seconds",
+	} {
+		f := findFamily(families, name)
+		require.NotNil(t, f, name)
+		require.Len(t, f.GetMetric(), 1, name)
+		assert.Equal(t, uint64(1), f.GetMetric()[0].GetHistogram().GetSampleCount(), name)
+		assert.True(t, hasExemplar(f.GetMetric()[0].GetHistogram()), "expected a trace ID exemplar on %s", name)
+	}
+	assert.True(t, hasSeries(families, "plugins_sql_connection_acquire_duration_seconds", "result", "hit"))
+
+	inFlight := findFamily(families, "plugins_sql_queries_in_flight")
+	require.NotNil(t, inFlight)
+	assert.Zero(t, inFlight.GetMetric()[0].GetGauge().GetValue())
+}
+
+func TestStageMetrics_Retries(t *testing.T) {
+	opts := test.DriverOpts{
+		QueryError: transientError{},
+	}
+	name := "stage_metrics_retries"
+	driver, _ := test.NewDriver(name, test.Data{}, nil, opts, nil)
+	ds := sqlds.NewDatasource(driver)
+	reg := prometheus.NewRegistry()
+	ds.MetricsProvider = sqlds.NewMetricsProvider(reg)
+
+	req, settings := setupQueryRequest(name, `{ "retries": 2, "retryOn": ["transient"] }`)
+	_, err := ds.NewDatasource(context.Background(), settings)
+	require.NoError(t, err)
+	_, err = ds.QueryData(context.Background(), req)
+	require.NoError(t, err)
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	retries := findFamily(families, "plugins_sql_retries_total")
+	assert.Nil(t, retries, "expected retries to be counted by plugins_sql_retry_attempts_total only")
+	attempts := findFamily(families, "plugins_sql_retry_attempts_total")
+	require.NotNil(t, attempts)
+	require.Len(t, attempts.GetMetric(), 2, "expected a series per attempt")
+	assert.True(t, hasSeries(families, "plugins_sql_retry_attempts_total", "reason", "error"))
+	var total float64
+	for _, m := range attempts.GetMetric() {
+		total += m.GetCounter().GetValue()
+	}
+	assert.Equal(t, float64(2), total)
+
+	execution := findFamily(families, "plugins_sql_query_execution_duration_seconds")
+	require.NotNil(t, execution)
+	assert.True(t, hasSeries(families, "plugins_sql_query_execution_duration_seconds", "status", "error"))
+	assert.Equal(t, uint64(3), execution.GetMetric()[0].GetHistogram().GetSampleCount())
+}
+
+func TestStageMetrics_Reconnects(t *testing.T) {
+	name := "stage_metrics_reconnects"
+	driver, _ := test.NewDriver(name, test.Data{}, nil, test.DriverOpts{}, nil)
+	reg := prometheus.NewRegistry()
+	_, settings := setupQueryRequest(name, "{}")
+	conn, err := sqlds.NewConnector(context.Background(), driver, settings, false, sqlds.WithMetricsProvider(sqlds.NewMetricsProvider(reg)))
+	require.NoError(t, err)
+
+	q := &sqlds.Query{}
+	key, dbConn, err := conn.GetConnectionFromQuery(context.Background(), q)
+	require.NoError(t, err)
+	_, err = conn.Reconnect(context.Background(), dbConn, q, key)
+	require.NoError(t, err)
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	reconnects := findFamily(families, "plugins_sql_reconnects_total")
+	require.NotNil(t, reconnects)
+	assert.True(t, hasSeries(families, "plugins_sql_reconnects_total", "status", "ok"))
+	assert.Equal(t, float64(1), reconnects.GetMetric()[0].GetCounter().GetValue())
+}
+
+func findFamily(families []*dto.MetricFamily, name string) *dto.MetricFamily {
+	for _, f := range families {
+		if f.GetName() == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// hasSeries reports whether the named family holds a series with the label
+// set to value.
+func hasSeries(families []*dto.MetricFamily, name, label, value string) bool {
+	f := findFamily(families, name)
+	if f == nil {
+		return false
+	}
+	for _, m := range f.GetMetric() {
+		for _, l := range m.GetLabel() {
+			if l.GetName() == label && l.GetValue() == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasExemplar(h *dto.Histogram) bool {
+	for _, b := range h.GetBucket() {
+		for _, l := range b.GetExemplar().GetLabel() {
+			if l.GetName() == "trace_id" && l.GetValue() != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package sqlds
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Defaults of PoolSettings, matching those of Grafana's built-in SQL
+// datasources.
+const (
+	DefaultMaxOpenConns    = 100
+	DefaultMaxIdleConns    = 100
+	DefaultConnMaxLifetime = 4 * time.Hour
+	DefaultConnMaxIdleTime = 10 * time.Minute
+)
+
+// ErrorPoolSettings is returned when the connection pool settings in a
+// datasource's jsonData are invalid.
+var ErrorPoolSettings = errors.New("invalid connection pool settings")
+
+// PoolSettings tune the database/sql connection pool of every *sql.DB a
+// Connector stores: the bootstrap connection, the connections opened for
+// ConnectionArgs and the replacements made by Reconnect. They take precedence
+// over any pool settings Driver.Connect applies itself.
+//
+// A zero field uses its default, so every datasource behaves the same under
+// load. A negative field removes the limit, as the zero value does in
+// database/sql, except for MaxIdleConns, where it keeps no idle connections.
+type PoolSettings struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// NoDefaults leaves the fields that are zero as Driver.Connect
+	// configured them, for drivers that tune their own pool. False (the
+	// default) sets them to their defaults.
+	NoDefaults bool
+}
+
+// poolSettingsJSON are the fields of a datasource's jsonData that override
+// the PoolSettings of the driver. Durations are a duration string such as
+// "30m", or a number of seconds.
+type poolSettingsJSON struct {
+	MaxOpenConns    int             `json:"maxOpenConns"`
+	MaxIdleConns    int             `json:"maxIdleConns"`
+	ConnMaxLifetime json.RawMessage `json:"connMaxLifetime"`
+	ConnMaxIdleTime json.RawMessage `json:"connMaxIdleTime"`
+}
+
+// LoadPoolSettings reads the maxOpenConns, maxIdleConns, connMaxLifetime and
+// connMaxIdleTime fields of a datasource's jsonData. Fields that are absent
+// are left zero.
+func LoadPoolSettings(jsonData json.RawMessage) (PoolSettings, error) {
+	if len(jsonData) == 0 {
+		return PoolSettings{}, nil
+	}
+	var raw poolSettingsJSON
+	if err := json.Unmarshal(jsonData, &raw); err != nil {
+		return PoolSettings{}, fmt.Errorf("%w: %w", ErrorPoolSettings, err)
+	}
+	lifetime, err := parsePoolDuration(raw.ConnMaxLifetime)
+	if err != nil {
+		return PoolSettings{}, fmt.Errorf("%w: connMaxLifetime: %w", ErrorPoolSettings, err)
+	}
+	idleTime, err := parsePoolDuration(raw.ConnMaxIdleTime)
+	if err != nil {
+		return PoolSettings{}, fmt.Errorf("%w: connMaxIdleTime: %w", ErrorPoolSettings, err)
+	}
+	return PoolSettings{
+		MaxOpenConns:    raw.MaxOpenConns,
+		MaxIdleConns:    raw.MaxIdleConns,
+		ConnMaxLifetime: lifetime,
+		ConnMaxIdleTime: idleTime,
+	}, nil
+}
+
+func parsePoolDuration(raw json.RawMessage) (time.Duration, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return 0, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return time.ParseDuration(s)
+	}
+	var seconds float64
+	if err := json.Unmarshal(raw, &seconds); err != nil {
+		return 0, fmt.Errorf("must be a duration or a number of seconds, got %s", raw)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// override returns p with the non-zero fields of o.
+func (p PoolSettings) override(o PoolSettings) PoolSettings {
+	if o.MaxOpenConns != 0 {
+		p.MaxOpenConns = o.MaxOpenConns
+	}
+	if o.MaxIdleConns != 0 {
+		p.MaxIdleConns = o.MaxIdleConns
+	}
+	if o.ConnMaxLifetime != 0 {
+		p.ConnMaxLifetime = o.ConnMaxLifetime
+	}
+	if o.ConnMaxIdleTime != 0 {
+		p.ConnMaxIdleTime = o.ConnMaxIdleTime
+	}
+	return p
+}
+
+// withDefaults returns p with its zero fields set to their defaults, unless
+// p opts out of them.
+func (p PoolSettings) withDefaults() PoolSettings {
+	if p.NoDefaults {
+		return p
+	}
+	return PoolSettings{
+		MaxOpenConns:    DefaultMaxOpenConns,
+		MaxIdleConns:    DefaultMaxIdleConns,
+		ConnMaxLifetime: DefaultConnMaxLifetime,
+		ConnMaxIdleTime: DefaultConnMaxIdleTime,
+	}.override(p)
+}
+
+// apply configures the pool of db with the fields of p that are set, which
+// is all of them once its defaults are applied.
+func (p PoolSettings) apply(db *sql.DB) {
+	if p.MaxOpenConns != 0 {
+		db.SetMaxOpenConns(max(p.MaxOpenConns, 0))
+	}
+	if p.MaxIdleConns != 0 {
+		db.SetMaxIdleConns(max(p.MaxIdleConns, 0))
+	}
+	if p.ConnMaxLifetime != 0 {
+		db.SetConnMaxLifetime(max(p.ConnMaxLifetime, 0))
+	}
+	if p.ConnMaxIdleTime != 0 {
+		db.SetConnMaxIdleTime(max(p.ConnMaxIdleTime, 0))
+	}
+}
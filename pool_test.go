@@ -0,0 +1,155 @@
+package sqlds_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/sqlds/v5"
+	"github.com/grafana/sqlds/v5/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPoolSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		jsonData string
+		want     sqlds.PoolSettings
+		err      bool
+	}{
+		{name: "empty", jsonData: "", want: sqlds.PoolSettings{}},
+		{name: "absent", jsonData: `{"timeout": 10}`, want: sqlds.PoolSettings{}},
+		{
+			name:     "seconds",
+			jsonData: `{"maxOpenConns": 10, "maxIdleConns": -1, "connMaxLifetime": 3600, "connMaxIdleTime": 0.5}`,
+			want:     sqlds.PoolSettings{MaxOpenConns: 10, MaxIdleConns: -1, ConnMaxLifetime: time.Hour, ConnMaxIdleTime: 500 * time.Millisecond},
+		},
+		{
+			name:     "durations",
+			jsonData: `{"connMaxLifetime": "30m", "connMaxIdleTime": "-1s"}`,
+			want:     sqlds.PoolSettings{ConnMaxLifetime: 30 * time.Minute, ConnMaxIdleTime: -time.Second},
+		},
+		{name: "bad duration", jsonData: `{"connMaxLifetime": "soon"}`, err: true},
+		{name: "bad type", jsonData: `{"maxOpenConns": "ten"}`, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sqlds.LoadPoolSettings(json.RawMessage(tt.jsonData))
+			if tt.err {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, sqlds.ErrorPoolSettings))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestConnector_AppliesPoolSettings(t *testing.T) {
+	name := "pool-settings"
+	driver, _ := test.NewDriver(name, test.Data{}, nil, test.DriverOpts{}, nil)
+	_, settings := setupQueryRequest(name, `{"maxOpenConns": 7}`)
+	conn, err := sqlds.NewConnector(context.Background(), driver, settings, true)
+	require.NoError(t, err)
+
+	key, bootstrap, err := conn.GetConnectionFromQuery(context.Background(), &sqlds.Query{})
+	require.NoError(t, err)
+	assert.Equal(t, 7, bootstrap.DB().Stats().MaxOpenConnections, "bootstrap connection")
+
+	args := &sqlds.Query{ConnectionArgs: json.RawMessage(`{"db":"other"}`)}
+	_, perArgs, err := conn.GetConnectionFromQuery(context.Background(), args)
+	require.NoError(t, err)
+	assert.Equal(t, 7, perArgs.DB().Stats().MaxOpenConnections, "connection for ConnectionArgs")
+
+	replacement, err := conn.Reconnect(context.Background(), bootstrap, &sqlds.Query{}, key)
+	require.NoError(t, err)
+	assert.Equal(t, 7, replacement.Stats().MaxOpenConnections, "reconnected connection")
+}
+
+func TestConnector_DefaultPoolSettings(t *testing.T) {
+	name := "pool-settings-default"
+	driver, _ := test.NewDriver(name, test.Data{}, nil, test.DriverOpts{}, nil)
+	_, settings := setupQueryRequest(name, "{}")
+	conn, err := sqlds.NewConnector(context.Background(), connectPoolDriver{driver}, settings, false)
+	require.NoError(t, err)
+
+	_, dbConn, err := conn.GetConnectionFromQuery(context.Background(), &sqlds.Query{})
+	require.NoError(t, err)
+	assert.Equal(t, sqlds.DefaultMaxOpenConns, dbConn.DB().Stats().MaxOpenConnections, "expected the defaults to replace the pool settings of Connect")
+}
+
+// connectPoolDriver configures the pool of its connections in Connect, as
+// drivers tuning their own pools do.
+type connectPoolDriver struct {
+	sqlds.Driver
+}
+
+func (d connectPoolDriver) Connect(ctx context.Context, config backend.DataSourceInstanceSettings, args json.RawMessage) (*sql.DB, error) {
+	db, err := d.Driver.Connect(ctx, config, args)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(5)
+	return db, nil
+}
+
+func TestConnector_NoDefaultPoolSettings(t *testing.T) {
+	for _, jsonData := range []string{"{}", `{"maxIdleConns": 2, "connMaxLifetime": "1h"}`} {
+		name := "pool-settings-driver-connect"
+		driver, _ := test.NewDriver(name, test.Data{}, nil, test.DriverOpts{}, nil)
+		_, settings := setupQueryRequest(name, jsonData)
+		conn, err := sqlds.NewConnector(context.Background(), poolDriver{connectPoolDriver{driver}, sqlds.PoolSettings{NoDefaults: true}}, settings, false)
+		require.NoError(t, err)
+
+		_, dbConn, err := conn.GetConnectionFromQuery(context.Background(), &sqlds.Query{})
+		require.NoError(t, err)
+		assert.Equal(t, 5, dbConn.DB().Stats().MaxOpenConnections, "expected the pool settings of Connect to be kept with %s", jsonData)
+	}
+}
+
+// poolDriver sets PoolSettings on the DriverSettings of the wrapped driver.
+type poolDriver struct {
+	sqlds.Driver
+	pool sqlds.PoolSettings
+}
+
+func (d poolDriver) Settings(ctx context.Context, config backend.DataSourceInstanceSettings) sqlds.DriverSettings {
+	settings := d.Driver.Settings(ctx, config)
+	settings.Pool = d.pool
+	return settings
+}
+
+func TestConnector_PoolSettingsPrecedence(t *testing.T) {
+	name := "pool-settings-precedence"
+	driver, _ := test.NewDriver(name, test.Data{}, nil, test.DriverOpts{}, nil)
+	_, settings := setupQueryRequest(name, `{"maxOpenConns": 3}`)
+	conn, err := sqlds.NewConnector(context.Background(), poolDriver{driver, sqlds.PoolSettings{MaxOpenConns: 20}}, settings, false)
+	require.NoError(t, err)
+	_, dbConn, err := conn.GetConnectionFromQuery(context.Background(), &sqlds.Query{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, dbConn.DB().Stats().MaxOpenConnections, "expected jsonData to override the driver")
+
+	name = "pool-settings-unlimited"
+	driver, _ = test.NewDriver(name, test.Data{}, nil, test.DriverOpts{}, nil)
+	_, settings = setupQueryRequest(name, `{"maxOpenConns": -5}`)
+	conn, err = sqlds.NewConnector(context.Background(), poolDriver{driver, sqlds.PoolSettings{MaxOpenConns: 20}}, settings, false)
+	require.NoError(t, err)
+	_, dbConn, err = conn.GetConnectionFromQuery(context.Background(), &sqlds.Query{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, dbConn.DB().Stats().MaxOpenConnections, "expected a negative value to remove the limit")
+
+	name = "pool-settings-driver-only"
+	driver, _ = test.NewDriver(name, test.Data{}, nil, test.DriverOpts{}, nil)
+	_, settings = setupQueryRequest(name, `{}`)
+	conn, err = sqlds.NewConnector(context.Background(), poolDriver{driver, sqlds.PoolSettings{MaxOpenConns: 20}}, settings, false)
+	require.NoError(t, err)
+	_, dbConn, err = conn.GetConnectionFromQuery(context.Background(), &sqlds.Query{})
+	require.NoError(t, err)
+	assert.Equal(t, 20, dbConn.DB().Stats().MaxOpenConnections, "expected the driver setting without a jsonData one")
+}
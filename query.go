@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"time"
 
@@ -65,6 +66,12 @@ type DBQuery struct {
 	rowLimit        int64
 	rowCapacityHint int64
 	thresholds      responseobs.Thresholds
+	// exactResponseBytes measures response bytes by marshaling frames to
+	// Arrow rather than estimating them.
+	exactResponseBytes bool
+	// backslashEscapes is passed to lexSQL when script statements are
+	// checked for placeholders.
+	backslashEscapes bool
 }
 
 func NewQuery(db Connection, settings backend.DataSourceInstanceSettings, converters []sqlutil.Converter, fillMode *data.FillMissing, rowLimit int64) *DBQuery {
@@ -97,19 +104,66 @@ func (q *DBQuery) WithResponseThresholds(t responseobs.Thresholds) *DBQuery {
 	return q
 }
 
+// WithMetrics sets the Metrics the query records its duration and response
+// size to, and whose provider receives its response observations. Returns
+// the receiver to allow chaining after NewQuery.
+func (q *DBQuery) WithMetrics(m Metrics) *DBQuery {
+	q.metrics = m
+	return q
+}
+
+// WithExactResponseBytes makes the response bytes reported to responseobs and
+// metrics exact, measured by marshaling the frames to Arrow, instead of
+// estimated. Returns the receiver to allow chaining after NewQuery.
+func (q *DBQuery) WithExactResponseBytes(exact bool) *DBQuery {
+	q.exactResponseBytes = exact
+	return q
+}
+
+// WithBackslashEscapes sets whether a backslash escapes the next character
+// in string literals, as DriverSettings.BackslashEscapes does, when RunScript
+// and StreamScript look for placeholders in statements. Returns the receiver
+// to allow chaining after NewQuery.
+func (q *DBQuery) WithBackslashEscapes(enabled bool) *DBQuery {
+	q.backslashEscapes = enabled
+	return q
+}
+
 // Run sends the query to the connection and converts the rows to a dataframe.
 func (q *DBQuery) Run(ctx context.Context, query *Query, queryErrorMutator QueryErrorMutator, args ...interface{}) (data.Frames, error) {
 	start := time.Now()
-	rows, err := q.DB.QueryContext(ctx, query.RawSQL, args...)
+	rows, err := q.queryRows(ctx, q.DB, query, queryErrorMutator, args...)
+	if err != nil {
+		return sqlutil.ErrorFrameFromQuery(query), err
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil {
+			backend.Logger.Error(err.Error())
+		}
+	}()
+
+	return q.convertRowsToFrames(ctx, rows, query, queryErrorMutator, start)
+}
+
+// queryRows sends query.RawSQL to db, classifying and recording any error.
+// Failures other than cancellation are wrapped with ErrorQuery so the
+// datasource can retry them.
+func (q *DBQuery) queryRows(ctx context.Context, db Connection, query *Query, queryErrorMutator QueryErrorMutator, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	qctx, span := startSpan(ctx, "QueryContext", attributeRefID.String(query.RefID))
+	rows, err := db.QueryContext(qctx, query.RawSQL, args...)
+	endSpan(span, err)
 	if err != nil {
 		var errWithSource backend.ErrorWithSource
 		defer func() {
 			q.metrics.CollectDuration(Source(errWithSource.ErrorSource()), StatusError, time.Since(start).Seconds())
+			q.metrics.CollectExecution(qctx, StatusError, time.Since(start).Seconds())
 		}()
 
 		if errors.Is(err, context.Canceled) {
 			errWithSource := backend.NewErrorWithSource(err, backend.ErrorSourceDownstream)
-			return sqlutil.ErrorFrameFromQuery(query), errWithSource
+			return nil, errWithSource
 		}
 
 		// Wrap with ErrorQuery to enable retry logic in datasource
@@ -118,52 +172,87 @@ func (q *DBQuery) Run(ctx context.Context, query *Query, queryErrorMutator Query
 		// Handle driver specific errors
 		if queryErrorMutator != nil {
 			errWithSource = queryErrorMutator.MutateQueryError(queryErr)
-			return sqlutil.ErrorFrameFromQuery(query), errWithSource
+			return nil, errWithSource
 		}
 
 		// If we get to this point, assume the error is from the plugin
 		errWithSource = backend.NewErrorWithSource(queryErr, backend.DefaultErrorSource)
 
-		return sqlutil.ErrorFrameFromQuery(query), errWithSource
+		return nil, errWithSource
 	}
 	q.metrics.CollectDuration(SourceDownstream, StatusOK, time.Since(start).Seconds())
+	q.metrics.CollectExecution(qctx, StatusOK, time.Since(start).Seconds())
 
 	// Check for an error response
 	if err := rows.Err(); err != nil {
+		if cerr := rows.Close(); cerr != nil {
+			backend.Logger.Error(cerr.Error())
+		}
 		queryErr := fmt.Errorf("%w: %w", ErrorQuery, err)
 		errWithSource := backend.NewErrorWithSource(queryErr, backend.DefaultErrorSource)
 		if errors.Is(err, sql.ErrNoRows) {
 			// Should we even response with an error here?
 			// The panel will simply show "no data"
 			errWithSource = backend.NewErrorWithSource(fmt.Errorf("%w: %s", err, "Error response from database"), backend.ErrorSourceDownstream)
-			return sqlutil.ErrorFrameFromQuery(query), errWithSource
+			return nil, errWithSource
 		}
 		if queryErrorMutator != nil {
 			errWithSource = queryErrorMutator.MutateQueryError(queryErr)
 		}
 
 		q.metrics.CollectDuration(Source(errWithSource.ErrorSource()), StatusError, time.Since(start).Seconds())
-		return sqlutil.ErrorFrameFromQuery(query), errWithSource
+		return nil, errWithSource
 	}
 
-	defer func() {
-		if err := rows.Close(); err != nil {
-			backend.Logger.Error(err.Error())
-		}
-	}()
-
-	return q.convertRowsToFrames(ctx, rows, query, queryErrorMutator, start)
+	return rows, nil
 }
 
 func (q *DBQuery) convertRowsToFrames(ctx context.Context, rows *sql.Rows, query *Query, queryErrorMutator QueryErrorMutator, runStart time.Time) (data.Frames, error) {
+	res, err := q.framesFromRows(ctx, rows, query, newRowBudget(q.rowLimit), queryErrorMutator)
+	if err != nil {
+		return sqlutil.ErrorFrameFromQuery(query), err
+	}
+
+	q.observeResponseSize(ctx, res, query, runStart)
+
+	return res, nil
+}
+
+// framesFromRows converts rows to frames in the query's format, reading at
+// most the rows left in budget, recording the conversion time and
+// classifying any error.
+func (q *DBQuery) framesFromRows(ctx context.Context, rows *sql.Rows, query *Query, budget *rowBudget, queryErrorMutator QueryErrorMutator) (data.Frames, error) {
+	var res data.Frames
+	err := q.convertRows(ctx, query, queryErrorMutator, func() (int64, error) {
+		var err error
+		res, err = getFrames(rows, budget, q.rowCapacityHint, q.converters, q.fillMode, query)
+		return frameRows(res), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// convertRows runs convert, which reads rows into frames and returns the
+// number of rows read, recording the conversion time and classifying any
+// error. Both Run and Stream read rows through it, so their errors are
+// reported alike.
+func (q *DBQuery) convertRows(ctx context.Context, query *Query, queryErrorMutator QueryErrorMutator, convert func() (int64, error)) error {
 	source := SourcePlugin
 	status := StatusOK
 	start := time.Now()
+	fctx, span := startSpan(ctx, "FrameFromRows", attributeRefID.String(query.RefID), formatAttribute(query.Format))
+	var err error
 	defer func() {
 		q.metrics.CollectDuration(source, status, time.Since(start).Seconds())
+		q.metrics.CollectFrameConversion(fctx, status, time.Since(start).Seconds())
+		endSpan(span, err)
 	}()
 
-	res, err := getFrames(rows, q.rowLimit, q.rowCapacityHint, q.converters, q.fillMode, query)
+	var rows int64
+	rows, err = convert()
+	span.SetAttributes(attributeRows.Int64(rows))
 	if err != nil {
 		status = StatusError
 
@@ -175,28 +264,39 @@ func (q *DBQuery) convertRowsToFrames(ctx context.Context, rows *sql.Rows, query
 			source = Source(errWithSource.ErrorSource())
 		}
 
-		return sqlutil.ErrorFrameFromQuery(query), backend.NewErrorWithSource(
+		err = backend.NewErrorWithSource(
 			fmt.Errorf("%w: %s", err, "Could not process SQL results"),
 			backend.ErrorSource(source),
 		)
+		return err
 	}
+	return nil
+}
 
-	q.observeResponseSize(ctx, res, query.RefID, runStart)
-
-	return res, nil
+// frameRows counts the rows across frames.
+func frameRows(frames data.Frames) int64 {
+	var rows int64
+	for _, frame := range frames {
+		if frame != nil && len(frame.Fields) > 0 {
+			rows += int64(frame.Fields[0].Len())
+		}
+	}
+	return rows
 }
 
-// observeResponseSize records rows + cells (rows × fields) across all returned frames
+// observeResponseSize records rows, cells (rows × fields) and bytes across all returned frames
 // and, if thresholds are configured, hands the observation to responseobs for
 // structured large-response logging. Skips emission entirely if any frame has
 // inconsistent field lengths, since partial totals would mislead operators
 // investigating large responses.
-func (q *DBQuery) observeResponseSize(ctx context.Context, frames data.Frames, refID string, runStart time.Time) {
-	var totalRows, totalCells int64
+func (q *DBQuery) observeResponseSize(ctx context.Context, frames data.Frames, query *Query, runStart time.Time) {
+	queryHash := QueryFingerprint(query.RawSQL)
+	var totalRows, totalCells, totalBytes int64
 	for _, frame := range frames {
 		if frame == nil {
 			continue
 		}
+		setFrameQueryHash(frame, queryHash)
 		rowLen, err := frame.RowLen()
 		if err != nil {
 			backend.Logger.Debug("skipping response size observation", "error", err.Error())
@@ -204,45 +304,267 @@ func (q *DBQuery) observeResponseSize(ctx context.Context, frames data.Frames, r
 		}
 		totalRows += int64(rowLen)
 		totalCells += int64(rowLen) * int64(len(frame.Fields))
+		totalBytes += q.frameBytes(frame)
 	}
+	q.observe(ctx, totalRows, totalCells, totalBytes, query.RefID, queryHash, runStart)
+}
+
+// observe records already-counted response totals. Streamed queries call it
+// directly since their frames are gone by the time the totals are known.
+func (q *DBQuery) observe(ctx context.Context, totalRows, totalCells, totalBytes int64, refID, queryHash string, runStart time.Time) {
 	q.metrics.CollectResponseSize(totalRows, totalCells)
+	q.metrics.CollectResponseBytes(totalBytes)
 
-	responseobs.Observe(ctx, responseobs.Observation{
+	q.metrics.collectors().Responses.Observe(ctx, responseobs.Observation{
 		Datasource: q.Settings,
+		Bytes:      totalBytes,
 		Rows:       totalRows,
 		Cells:      totalCells,
 		Duration:   time.Since(runStart),
 		RefID:      refID,
+		QueryHash:  queryHash,
 	}, q.thresholds)
 }
 
-// getFrames converts rows to dataframes.
-// If capacity > 0, the Frame's Fields are presized via
-// sqlutil.FrameFromRowsWithCapacity to avoid per-column slice growth during
-// scanning. Passing 0 preserves the historical FrameFromRows behavior.
-func getFrames(rows *sql.Rows, limit int64, capacity int64, converters []sqlutil.Converter, fillMode *data.FillMissing, query *Query) (data.Frames, error) {
+// getFrames converts rows to dataframes, one result set at a time.
+// If capacity > 0, each result set's Fields are presized to avoid per-column
+// slice growth during scanning. Passing 0 preserves the historical behavior
+// of growing Fields as rows arrive.
+//
+// A statement returning a single result set produces frames named after the
+// query's RefID, as it always has. When the statement returns several result
+// sets (a stored procedure or a batch), the query format is applied to each
+// set separately and its frames are named RefID-<index>, starting at 0.
+func getFrames(rows *sql.Rows, budget *rowBudget, capacity int64, converters []sqlutil.Converter, fillMode *data.FillMissing, query *Query) (data.Frames, error) {
 	// Validate rows before processing to prevent panics
 	if err := validateRows(rows); err != nil {
 		backend.Logger.Error("Invalid SQL rows", "error", err.Error())
 		return nil, err
 	}
 
-	var (
-		frame *data.Frame
-		err   error
-	)
+	sets, err := readResultSets(rows, budget, capacity, converters)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sets) == 1 {
+		return formatFrame(sets[0], query.RefID, fillMode, query)
+	}
+
+	var res data.Frames
+	for i, set := range sets {
+		frames, err := formatFrame(set, fmt.Sprintf("%s-%d", query.RefID, i), fillMode, query)
+		// An empty set does not hide the sets that did return rows.
+		if errors.Is(err, ErrorNoResults) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, frames...)
+	}
+	if len(res) == 0 {
+		return nil, ErrorNoResults
+	}
+	return res, nil
+}
+
+// rowBudget is the row limit of a query and the rows still left under it.
+// The statements of a script share one budget, so the row limit applies to
+// the script as a whole rather than to each statement.
+type rowBudget struct {
+	limit     int64
+	remaining int64
+}
+
+// newRowBudget returns a budget of limit rows; a negative limit is unlimited.
+func newRowBudget(limit int64) *rowBudget {
+	remaining := limit
+	if remaining < 0 {
+		remaining = math.MaxInt64
+	}
+	return &rowBudget{limit: limit, remaining: remaining}
+}
+
+// take subtracts the rows of frame from the budget, attaching the row limit
+// notice to frame when they use up what was left.
+func (b *rowBudget) take(frame *data.Frame) {
+	rows := int64(frame.Rows())
+	b.remaining -= rows
+	if b.remaining <= 0 && rows > 0 {
+		frame.AppendNotices(b.notice())
+	}
+}
+
+// notice is the warning attached to the frame that reached the row limit.
+func (b *rowBudget) notice() data.Notice {
+	return data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text:     fmt.Sprintf("Results have been limited to %v because the SQL row limit was reached", b.limit),
+	}
+}
+
+// readResultSets reads every result set in rows into its own frame. The row
+// limit applies to the total across all sets, taken from budget; once it is
+// reached the remaining sets are not read.
+func readResultSets(rows *sql.Rows, budget *rowBudget, capacity int64, converters []sqlutil.Converter) ([]*data.Frame, error) {
+	// The SDK's dynamic framer infers field types from the data and has no way
+	// to stop at the end of a result set, so it still reads every set into a
+	// single frame.
+	if hasDynamicConverter(converters) {
+		var (
+			frame *data.Frame
+			err   error
+		)
+		if capacity > 0 {
+			// RowCapacityHint is int64 (row-count domain, like rowLimit); SetRowCapacity
+			// takes int. Safe to narrow: on 64-bit int == int64, and an overflow on 32-bit
+			// trips the SDK's `capacity > 0` guard and just skips presizing.
+			frame, err = sqlutil.FrameFromRowsWithCapacity(rows, budget.remaining, int(capacity), converters...)
+		} else {
+			frame, err = sqlutil.FrameFromRows(rows, budget.remaining, converters...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The SDK's notice names the rows that were left rather than the
+		// query's row limit, so it is replaced by the budget's own.
+		if frame.Meta != nil {
+			frame.Meta.Notices = nil
+		}
+		budget.take(frame)
+		return []*data.Frame{frame}, nil
+	}
+
+	var sets []*data.Frame
+	for {
+		frame, err := frameFromResultSet(rows, budget.remaining, capacity, converters)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, frame)
+
+		budget.take(frame)
+		if budget.remaining <= 0 {
+			break
+		}
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, backend.DownstreamError(err)
+	}
+	return sets, nil
+}
+
+// frameFromResultSet reads up to limit rows of the current result set into a
+// new frame without advancing to the next result set.
+func frameFromResultSet(rows *sql.Rows, limit int64, capacity int64, converters []sqlutil.Converter) (*data.Frame, error) {
+	reader, err := newResultSetReader(rows, converters)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := reader.newFrame()
 	if capacity > 0 {
-		// RowCapacityHint is int64 (row-count domain, like rowLimit); SetRowCapacity
-		// takes int. Safe to narrow: on 64-bit int == int64, and an overflow on 32-bit
-		// trips the SDK's `capacity > 0` guard and just skips presizing.
-		frame, err = sqlutil.FrameFromRowsWithCapacity(rows, limit, int(capacity), converters...)
-	} else {
-		frame, err = sqlutil.FrameFromRows(rows, limit, converters...)
+		// See readResultSets for why narrowing capacity to int is safe.
+		frame.SetRowCapacity(int(capacity))
+	}
+
+	for n := int64(0); n < limit; n++ {
+		ok, err := reader.next(frame)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+	}
+	return frame, nil
+}
+
+// resultSetReader reads the rows of the current result set of rows into
+// frames, one row at a time. Run and Stream both build their frames with it.
+// Like sqlutil.FrameFromRows, it scans and converts every row into the same
+// buffers rather than allocating new ones per row as sqlutil.Append does.
+type resultSetReader struct {
+	rows      *sql.Rows
+	names     []string
+	scanRow   *sqlutil.RowConverter
+	scannable []interface{}
+	converted []interface{}
+}
+
+func newResultSetReader(rows *sql.Rows, converters []sqlutil.Converter) (*resultSetReader, error) {
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, err
 	}
+	scanRow, err := sqlutil.MakeScanRow(types, names, converters...)
 	if err != nil {
 		return nil, err
 	}
-	frame.Name = query.RefID
+	scannable := scanRow.NewScannableRow()
+	return &resultSetReader{
+		rows:      rows,
+		names:     names,
+		scanRow:   scanRow,
+		scannable: scannable,
+		converted: make([]interface{}, len(scannable)),
+	}, nil
+}
+
+// newFrame returns an empty frame with the fields of the result set.
+func (r *resultSetReader) newFrame() *data.Frame {
+	return sqlutil.NewFrame(r.names, r.scanRow.Converters...)
+}
+
+// next appends the next row of the result set to frame, reporting false once
+// the result set has no more rows.
+func (r *resultSetReader) next(frame *data.Frame) (bool, error) {
+	if !r.rows.Next() {
+		return false, nil
+	}
+	if err := r.rows.Scan(r.scannable...); err != nil {
+		return false, err
+	}
+	for i, v := range r.scannable {
+		converter := r.scanRow.Converters[i].FrameConverter
+		var err error
+		if converter.ConvertWithColumn != nil {
+			// sqlutil never sets the column type of its converters either.
+			r.converted[i], err = converter.ConvertWithColumn(v, sql.ColumnType{})
+		} else {
+			r.converted[i], err = converter.ConverterFunc(v)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	frame.AppendRow(r.converted...)
+	return true, nil
+}
+
+// hasDynamicConverter reports whether converters contains the SDK's dynamic
+// converter flag.
+func hasDynamicConverter(converters []sqlutil.Converter) bool {
+	for _, c := range converters {
+		if c.Dynamic {
+			return true
+		}
+	}
+	return false
+}
+
+// formatFrame names frame and applies the query format to it.
+func formatFrame(frame *data.Frame, name string, fillMode *data.FillMissing, query *Query) (data.Frames, error) {
+	frame.Name = name
 	if frame.Meta == nil {
 		frame.Meta = &data.FrameMeta{}
 	}
@@ -256,7 +578,7 @@ func getFrames(rows *sql.Rows, limit int64, capacity int64, converters []sqlutil
 	zeroRows := count == 0
 
 	frame.Meta.ExecutedQueryString = query.RawSQL
-	frame.Meta.PreferredVisualization = data.VisTypeGraph
+	frame.Meta.PreferredVisualization = preferredVisualization(query.Format)
 
 	switch query.Format {
 	case FormatOptionMulti:
@@ -277,12 +599,7 @@ func getFrames(rows *sql.Rows, limit int64, capacity int64, converters []sqlutil
 			}
 			return frames.Frames(), nil
 		}
-	case FormatOptionTable:
-		frame.Meta.PreferredVisualization = data.VisTypeTable
-	case FormatOptionLogs:
-		frame.Meta.PreferredVisualization = data.VisTypeLogs
-	case FormatOptionTrace:
-		frame.Meta.PreferredVisualization = data.VisTypeTrace
+	case FormatOptionTable, FormatOptionLogs, FormatOptionTrace:
 	// Format as timeSeries
 	default:
 		if zeroRows {
@@ -299,6 +616,21 @@ func getFrames(rows *sql.Rows, limit int64, capacity int64, converters []sqlutil
 	return data.Frames{frame}, nil
 }
 
+// preferredVisualization maps a query format to the visualization hint set
+// on its frames.
+func preferredVisualization(format FormatQueryOption) data.VisType {
+	switch format {
+	case FormatOptionTable:
+		return data.VisTypeTable
+	case FormatOptionLogs:
+		return data.VisTypeLogs
+	case FormatOptionTrace:
+		return data.VisTypeTrace
+	default:
+		return data.VisTypeGraph
+	}
+}
+
 // accessColumns checks whether we can access rows.Columns, checking
 // for error or panic. In the case of panic, logs the stack trace at debug level
 // for security
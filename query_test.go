@@ -414,7 +414,7 @@ func TestObserveResponseSize_MultipleFrames(t *testing.T) {
 	}
 
 	q := &DBQuery{metrics: NewMetrics("test-ds", dsType, EndpointQuery)}
-	q.observeResponseSize(context.Background(), frames, "A", time.Now())
+	q.observeResponseSize(context.Background(), frames, &Query{RefID: "A"}, time.Now())
 
 	rows := histogramSnapshot(t, responseRowsMetric, dsType)
 	require.Equal(t, uint64(1), rows.GetSampleCount())
@@ -436,10 +436,13 @@ func TestObserveResponseSize_ThresholdCrossed_EmitsLog(t *testing.T) {
 		metrics:    NewMetrics("ds1", "TestObserveResponseSize-cross", EndpointQuery),
 		thresholds: responseobs.Thresholds{Rows: 3},
 	}
-	q.observeResponseSize(context.Background(), data.Frames{frame}, "A", time.Now())
+	query := &Query{RefID: "A", RawSQL: "SELECT v FROM t WHERE id IN (1, 2)"}
+	q.observeResponseSize(context.Background(), data.Frames{frame}, query, time.Now())
 
 	require.Len(t, rec.entries, 1, "expected one large-response log")
 	assert.Equal(t, "large datasource response", rec.entries[0].msg)
+	assert.Contains(t, rec.entries[0].args, QueryFingerprint(query.RawSQL))
+	assert.Equal(t, map[string]any{"queryHash": QueryFingerprint(query.RawSQL)}, frame.Meta.Custom)
 }
 
 func TestObserveResponseSize_ThresholdNotCrossed_NoLog(t *testing.T) {
@@ -453,7 +456,7 @@ func TestObserveResponseSize_ThresholdNotCrossed_NoLog(t *testing.T) {
 		metrics:    NewMetrics("ds1", "TestObserveResponseSize-nocross", EndpointQuery),
 		thresholds: responseobs.Thresholds{Rows: 100},
 	}
-	q.observeResponseSize(context.Background(), data.Frames{frame}, "A", time.Now())
+	q.observeResponseSize(context.Background(), data.Frames{frame}, &Query{RefID: "A"}, time.Now())
 
 	assert.Empty(t, rec.entries, "no log expected below threshold")
 }
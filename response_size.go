@@ -0,0 +1,72 @@
+package sqlds
+
+import (
+	"encoding/json"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// arrowOffsetBytes is the size of the offset Arrow stores for every value of
+// a variable-width column.
+const arrowOffsetBytes = 4
+
+// frameBytes returns the serialized size of frame: exact when
+// DriverSettings.ExactResponseBytes is set, which marshals the frame to
+// Arrow, and otherwise estimated from its field types and lengths.
+func (q *DBQuery) frameBytes(frame *data.Frame) int64 {
+	if q.exactResponseBytes {
+		b, err := frame.MarshalArrow()
+		if err == nil {
+			return int64(len(b))
+		}
+		backend.Logger.Debug("falling back to estimated response bytes", "error", err.Error())
+	}
+	return estimateFrameBytes(frame)
+}
+
+// estimateFrameBytes approximates the Arrow-encoded size of frame without
+// encoding it. Fixed-width values count their width, strings and JSON their
+// length plus an offset, and nullable fields a validity bit per row. Schema
+// metadata is not counted, so the estimate runs slightly low for frames
+// with few rows.
+func estimateFrameBytes(frame *data.Frame) int64 {
+	var total int64
+	for _, field := range frame.Fields {
+		if field == nil {
+			continue
+		}
+		n := field.Len()
+		ft := field.Type()
+		total += int64(len(field.Name))
+		if ft.Nullable() {
+			total += int64((n + 7) / 8)
+		}
+
+		switch ft.NonNullableType() {
+		case data.FieldTypeInt8, data.FieldTypeUint8, data.FieldTypeBool:
+			total += int64(n)
+		case data.FieldTypeInt16, data.FieldTypeUint16, data.FieldTypeEnum:
+			total += 2 * int64(n)
+		case data.FieldTypeInt32, data.FieldTypeUint32, data.FieldTypeFloat32:
+			total += 4 * int64(n)
+		case data.FieldTypeInt64, data.FieldTypeUint64, data.FieldTypeFloat64, data.FieldTypeTime:
+			total += 8 * int64(n)
+		case data.FieldTypeString, data.FieldTypeJSON:
+			total += arrowOffsetBytes * int64(n)
+			for i := 0; i < n; i++ {
+				v, ok := field.ConcreteAt(i)
+				if !ok {
+					continue
+				}
+				switch v := v.(type) {
+				case string:
+					total += int64(len(v))
+				case json.RawMessage:
+					total += int64(len(v))
+				}
+			}
+		}
+	}
+	return total
+}
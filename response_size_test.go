@@ -0,0 +1,91 @@
+package sqlds
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/sqlds/v5/responseobs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateFrameBytes(t *testing.T) {
+	s := "abc"
+	frame := data.NewFrame("A",
+		data.NewField("i", nil, []int64{1, 2}),
+		data.NewField("f", nil, []float32{1, 2}),
+		data.NewField("b", nil, []bool{true, false}),
+		data.NewField("s", nil, []*string{&s, nil}),
+	)
+	// Names 4, int64 16, float32 8, bool 2, strings 2 offsets of 4 plus 3
+	// bytes plus a validity byte.
+	assert.Equal(t, int64(4+16+8+2+8+3+1), estimateFrameBytes(frame))
+}
+
+func TestEstimateFrameBytes_CloseToArrow(t *testing.T) {
+	values := make([]string, 200)
+	for i := range values {
+		values[i] = strings.Repeat("x", 1000)
+	}
+	frame := data.NewFrame("wide",
+		data.NewField("id", nil, make([]int64, len(values))),
+		data.NewField("payload", nil, values),
+	)
+
+	exact, err := frame.MarshalArrow()
+	require.NoError(t, err)
+	estimate := estimateFrameBytes(frame)
+	assert.InDelta(t, len(exact), estimate, float64(len(exact))*0.1, "estimate %d, exact %d", estimate, len(exact))
+}
+
+func TestDBQuery_FrameBytes_Exact(t *testing.T) {
+	frame := data.NewFrame("A", data.NewField("v", nil, []string{"a", "b"}))
+	exact, err := frame.MarshalArrow()
+	require.NoError(t, err)
+
+	q := (&DBQuery{}).WithExactResponseBytes(true)
+	assert.Equal(t, int64(len(exact)), q.frameBytes(frame))
+}
+
+func TestSQLDatasource_NewDBQuery(t *testing.T) {
+	thresholds := responseobs.Thresholds{Bytes: 4096, Rows: 100}
+	ds := &SQLDatasource{
+		connector: &Connector{driverSettings: DriverSettings{
+			ResponseThresholds: thresholds,
+			ExactResponseBytes: true,
+			BackslashEscapes:   true,
+		}},
+		rowCapacityHint: 10,
+	}
+
+	q := ds.newDBQuery(nil, backend.DataSourceInstanceSettings{Name: "ds1"}, nil, 5)
+	assert.Equal(t, thresholds, q.thresholds)
+	assert.True(t, q.exactResponseBytes)
+	assert.True(t, q.backslashEscapes)
+	assert.Equal(t, int64(10), q.rowCapacityHint)
+	assert.Equal(t, int64(5), q.rowLimit)
+}
+
+func TestObserveResponseSize_BytesThresholdCrossed(t *testing.T) {
+	rec := swapBackendLogger(t)
+
+	const dsType = "TestObserveResponseSize-bytes"
+	frame := data.NewFrame("wide",
+		data.NewField("payload", nil, []string{strings.Repeat("x", 4096)}),
+	)
+	q := &DBQuery{
+		Settings:   backend.DataSourceInstanceSettings{Type: dsType, UID: "uid1", Name: "ds1"},
+		metrics:    NewMetrics("ds1", dsType, EndpointQuery),
+		thresholds: responseobs.Thresholds{Bytes: 4096, Rows: 100},
+	}
+	q.observeResponseSize(context.Background(), data.Frames{frame}, &Query{RefID: "A"}, time.Now())
+
+	require.Len(t, rec.entries, 1, "expected the bytes threshold to trigger a large-response log")
+	bytes := histogramSnapshot(t, responseBytesMetric, dsType)
+	assert.Equal(t, uint64(1), bytes.GetSampleCount())
+	assert.Equal(t, float64(estimateFrameBytes(frame)), bytes.GetSampleSum())
+}
@@ -15,8 +15,24 @@ import (
 // "slug" label in the plan because backend.GrafanaConfig exposes no
 // dedicated slug accessor; downstream operators can derive a slug by
 // parsing the URL if needed.
-var largeResponsesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+var largeResponsesCounter = promauto.NewCounterVec(largeResponsesOpts, largeResponsesLabels)
+
+var largeResponsesOpts = prometheus.CounterOpts{
 	Namespace: "plugins",
 	Name:      "sql_large_responses_total",
-	Help:      "Number of SQL datasource responses that crossed a configured size threshold (rows or bytes).",
-}, []string{"datasource_type", "app_url", "datasource_uid"})
+	Help:      "Number of SQL datasource responses that crossed a configured size threshold (rows, cells or bytes).",
+}
+
+var largeResponsesLabels = []string{"datasource_type", "app_url", "datasource_uid"}
+
+// slowResponsesCounter increments once per Observation that crossed the
+// configured duration threshold. It is kept apart from the large-response
+// counter so that slow but small responses do not read as large ones. It
+// has the same labels, and so the same self-limiting cardinality.
+var slowResponsesCounter = promauto.NewCounterVec(slowResponsesOpts, largeResponsesLabels)
+
+var slowResponsesOpts = prometheus.CounterOpts{
+	Namespace: "plugins",
+	Name:      "sql_slow_responses_total",
+	Help:      "Number of SQL datasource responses that crossed a configured duration threshold.",
+}
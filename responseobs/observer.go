@@ -0,0 +1,129 @@
+package responseobs
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Observer is a sink for observations. Observe hands it every observation,
+// with large reporting whether a threshold was crossed, so sinks such as
+// usage recorders see all responses and not only the large ones. Observers
+// are called synchronously on the query's goroutine and should not block.
+type Observer interface {
+	ObserveResponse(ctx context.Context, obs Observation, large bool)
+}
+
+// ObserverFunc adapts a function to the Observer interface.
+type ObserverFunc func(ctx context.Context, obs Observation, large bool)
+
+// ObserveResponse implements Observer.
+func (f ObserverFunc) ObserveResponse(ctx context.Context, obs Observation, large bool) {
+	f(ctx, obs, large)
+}
+
+// Registry holds the observers Observe delivers observations to, besides the
+// built-in large- and slow-response logs. The zero value is ready to use, with
+// the logs enabled, and counts large and slow responses on the default
+// Prometheus registry.
+type Registry struct {
+	// counter and slowCounter are the large- and slow-response counters. Nil
+	// means the package-level counters on the default Prometheus registry.
+	counter     *prometheus.CounterVec
+	slowCounter *prometheus.CounterVec
+
+	mu          sync.RWMutex
+	next        int
+	observers   []registeredObserver
+	logDisabled bool
+}
+
+type registeredObserver struct {
+	id       int
+	observer Observer
+}
+
+// NewRegistry returns an empty Registry with the large- and slow-response
+// logs enabled.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewRegistryFor returns an empty Registry like NewRegistry, whose
+// large- and slow-response counters are registered on reg instead of the
+// default Prometheus registry. Like promauto, it panics if reg already holds
+// the counters.
+func NewRegistryFor(reg prometheus.Registerer) *Registry {
+	return &Registry{
+		counter:     promauto.With(reg).NewCounterVec(largeResponsesOpts, largeResponsesLabels),
+		slowCounter: promauto.With(reg).NewCounterVec(slowResponsesOpts, largeResponsesLabels),
+	}
+}
+
+func (r *Registry) largeResponses() *prometheus.CounterVec {
+	if r.counter == nil {
+		return largeResponsesCounter
+	}
+	return r.counter
+}
+
+func (r *Registry) slowResponses() *prometheus.CounterVec {
+	if r.slowCounter == nil {
+		return slowResponsesCounter
+	}
+	return r.slowCounter
+}
+
+// DefaultRegistry is the Registry used by the package-level Observe,
+// Register and SetLogEnabled, and by sqlds datasources without their own
+// metrics provider.
+var DefaultRegistry = NewRegistry()
+
+// Register adds o to the registry and returns a func that removes it again.
+// Observers are called in the order they were registered.
+func (r *Registry) Register(o Observer) (unregister func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.next++
+	id := r.next
+	r.observers = append(r.observers, registeredObserver{id: id, observer: o})
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for i, ro := range r.observers {
+			if ro.id == id {
+				r.observers = append(r.observers[:i:i], r.observers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// SetLogEnabled turns the built-in large- and slow-response warn logs on or
+// off. The plugins_sql_large_responses_total and
+// plugins_sql_slow_responses_total counters are kept either way.
+func (r *Registry) SetLogEnabled(enabled bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logDisabled = !enabled
+}
+
+func (r *Registry) snapshot() ([]registeredObserver, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.observers, !r.logDisabled
+}
+
+// Register adds o to DefaultRegistry.
+func Register(o Observer) (unregister func()) {
+	return DefaultRegistry.Register(o)
+}
+
+// SetLogEnabled turns the large- and slow-response logs of DefaultRegistry on
+// or off.
+func SetLogEnabled(enabled bool) {
+	DefaultRegistry.SetLogEnabled(enabled)
+}
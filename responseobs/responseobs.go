@@ -5,7 +5,8 @@
 // (rows, cells, optionally bytes) and a single Observe call handles
 // threshold evaluation and log emission. The return value signals whether
 // a threshold was crossed, so downstream consumers (e.g. a large-response
-// counter) can piggyback on the same decision point.
+// counter) can piggyback on the same decision point. Plugins add their own
+// sinks, which see every observation, by registering an Observer.
 //
 // Thresholds default to values appropriate for datasource plugins at
 // Grafana's managed-tenant scale; plugins with known-large responses can
@@ -27,16 +28,20 @@ const (
 )
 
 // Thresholds configures what counts as a "large" response. A zero on
-// either field disables that dimension — useful at measurement layers
-// where one of the two values is unavailable (e.g. sqlds can count rows
-// but not serialized bytes).
+// any field disables that dimension — useful at measurement layers
+// where one of the values is unavailable or only roughly estimated.
 type Thresholds struct {
 	Bytes int64
 	Rows  int64
+	Cells int64
+	// Duration marks responses that took at least this long as slow rather
+	// than large: they are counted and logged apart from the size
+	// thresholds.
+	Duration time.Duration
 }
 
 // Defaults returns Thresholds populated with DefaultBytesThreshold and
-// DefaultRowsThreshold.
+// DefaultRowsThreshold. Cells and Duration are left disabled.
 func Defaults() Thresholds {
 	return Thresholds{Bytes: DefaultBytesThreshold, Rows: DefaultRowsThreshold}
 }
@@ -55,17 +60,46 @@ type Observation struct {
 	QueryHash string
 }
 
-// Observe compares obs against t. If a threshold is crossed, it emits a
-// single structured warn log via backend.Logger, increments the
-// plugins_sql_large_responses_total counter, and returns true. Otherwise
-// it returns false and emits nothing. Intended to be called at most once
-// per response.
+// Observe hands obs to DefaultRegistry. See Registry.Observe.
 func Observe(ctx context.Context, obs Observation, t Thresholds) bool {
-	if !crosses(obs, t) {
-		return false
+	return DefaultRegistry.Observe(ctx, obs, t)
+}
+
+// Observe compares obs against t. If a size threshold (bytes, rows or cells)
+// is crossed, it emits a "large datasource response" warn log via
+// backend.Logger and increments the plugins_sql_large_responses_total
+// counter. If the duration threshold is crossed, it emits a "slow datasource
+// response" warn log and increments plugins_sql_slow_responses_total
+// instead, or as well. The logs can be disabled with SetLogEnabled. Every
+// observation is then handed to the registered observers, and Observe returns
+// whether any threshold was crossed. Intended to be called at most once per
+// response.
+func (r *Registry) Observe(ctx context.Context, obs Observation, t Thresholds) bool {
+	observers, logEnabled := r.snapshot()
+	large, slow := crossesSize(obs, t), crossesDuration(obs, t)
+	if large || slow {
+		appURL := appURLFromContext(ctx)
+		if large {
+			r.largeResponses().WithLabelValues(obs.Datasource.Type, appURL, obs.Datasource.UID).Inc()
+			if logEnabled {
+				logResponse("large datasource response", appURL, obs)
+			}
+		}
+		if slow {
+			r.slowResponses().WithLabelValues(obs.Datasource.Type, appURL, obs.Datasource.UID).Inc()
+			if logEnabled {
+				logResponse("slow datasource response", appURL, obs)
+			}
+		}
 	}
-	appURL := appURLFromContext(ctx)
-	backend.Logger.Warn("large datasource response",
+	for _, ro := range observers {
+		ro.observer.ObserveResponse(ctx, obs, large || slow)
+	}
+	return large || slow
+}
+
+func logResponse(msg, appURL string, obs Observation) {
+	backend.Logger.Warn(msg,
 		"app_url", appURL,
 		"datasource_type", obs.Datasource.Type,
 		"datasource_uid", obs.Datasource.UID,
@@ -77,18 +111,20 @@ func Observe(ctx context.Context, obs Observation, t Thresholds) bool {
 		"ref_id", obs.RefID,
 		"query_hash", obs.QueryHash,
 	)
-	largeResponsesCounter.WithLabelValues(obs.Datasource.Type, appURL, obs.Datasource.UID).Inc()
-	return true
 }
 
-func crosses(obs Observation, t Thresholds) bool {
+func crossesSize(obs Observation, t Thresholds) bool {
 	if t.Bytes > 0 && obs.Bytes >= t.Bytes {
 		return true
 	}
 	if t.Rows > 0 && obs.Rows >= t.Rows {
 		return true
 	}
-	return false
+	return t.Cells > 0 && obs.Cells >= t.Cells
+}
+
+func crossesDuration(obs Observation, t Thresholds) bool {
+	return t.Duration > 0 && obs.Duration >= t.Duration
 }
 
 // appURLFromContext returns the Grafana app URL from plugin context when
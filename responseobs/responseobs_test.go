@@ -8,12 +8,13 @@ import (
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func TestCrosses(t *testing.T) {
+func TestCrossesSize(t *testing.T) {
 	cases := []struct {
 		name   string
 		obs    Observation
@@ -62,14 +63,57 @@ func TestCrosses(t *testing.T) {
 			thresh: Thresholds{Rows: 1000},
 			want:   true,
 		},
+		{
+			name:   "cells crossed only",
+			obs:    Observation{Rows: 10, Cells: 5000},
+			thresh: Thresholds{Rows: 1000, Cells: 1000},
+			want:   true,
+		},
+		{
+			name:   "duration is not a size",
+			obs:    Observation{Rows: 10, Duration: 2 * time.Second},
+			thresh: Thresholds{Rows: 1000, Duration: time.Second},
+			want:   false,
+		},
+		{
+			name:   "cells and duration disabled by zero",
+			obs:    Observation{Cells: 5000, Duration: 2 * time.Second},
+			thresh: Thresholds{Rows: 1000},
+			want:   false,
+		},
 	}
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			assert.Equal(t, tc.want, crosses(tc.obs, tc.thresh))
+			assert.Equal(t, tc.want, crossesSize(tc.obs, tc.thresh))
 		})
 	}
 }
 
+func TestCrossesDuration(t *testing.T) {
+	assert.True(t, crossesDuration(Observation{Duration: time.Second}, Thresholds{Duration: time.Second}))
+	assert.False(t, crossesDuration(Observation{Duration: time.Second}, Thresholds{Duration: 2 * time.Second}))
+	assert.False(t, crossesDuration(Observation{Duration: time.Hour}, Thresholds{}))
+}
+
+func TestObserve_DurationOnly_CountsSlowResponse(t *testing.T) {
+	rec := swapLogger(t)
+
+	reg := prometheus.NewRegistry()
+	r := NewRegistryFor(reg)
+	ds := backend.DataSourceInstanceSettings{Type: "slow", UID: "ds-uid-slow"}
+	require.True(t, r.Observe(context.Background(),
+		Observation{Datasource: ds, Rows: 10, Duration: 2 * time.Second},
+		Thresholds{Rows: 1000, Duration: time.Second}))
+
+	require.Len(t, rec.entries, 1)
+	assert.Equal(t, "slow datasource response", rec.entries[0].msg)
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1, "expected only the slow-response counter")
+	assert.Equal(t, "plugins_sql_slow_responses_total", families[0].GetName())
+	assert.Equal(t, float64(1), families[0].GetMetric()[0].GetCounter().GetValue())
+}
+
 func TestDefaults(t *testing.T) {
 	d := Defaults()
 	assert.Equal(t, int64(50*1024*1024), d.Bytes)
@@ -178,6 +222,66 @@ func TestObserve_NoCross_DoesNotIncrementCounter(t *testing.T) {
 	assert.Equal(t, before, counterValue(t, ds.Type, "", ds.UID))
 }
 
+func TestRegistry_ObserversSeeEveryObservation(t *testing.T) {
+	swapLogger(t)
+
+	r := NewRegistry()
+	type call struct {
+		rows  int64
+		large bool
+	}
+	var calls []call
+	unregister := r.Register(ObserverFunc(func(_ context.Context, obs Observation, large bool) {
+		calls = append(calls, call{obs.Rows, large})
+	}))
+
+	assert.False(t, r.Observe(context.Background(), Observation{Rows: 10}, Thresholds{Rows: 100}))
+	assert.True(t, r.Observe(context.Background(), Observation{Rows: 100}, Thresholds{Rows: 100}))
+	assert.Equal(t, []call{{10, false}, {100, true}}, calls)
+
+	unregister()
+	r.Observe(context.Background(), Observation{Rows: 1}, Thresholds{Rows: 100})
+	assert.Len(t, calls, 2, "expected no calls after unregistering")
+}
+
+func TestRegistry_LogDisabled(t *testing.T) {
+	rec := swapLogger(t)
+
+	r := NewRegistry()
+	r.SetLogEnabled(false)
+	ds := backend.DataSourceInstanceSettings{Type: "log-disabled", UID: "ds-uid-3"}
+
+	before := counterValue(t, ds.Type, "", ds.UID)
+	ok := r.Observe(context.Background(),
+		Observation{Datasource: ds, Rows: DefaultRowsThreshold},
+		Thresholds{Rows: DefaultRowsThreshold})
+
+	require.True(t, ok)
+	assert.Empty(t, rec.entries, "no log expected with the log disabled")
+	assert.Equal(t, before+1, counterValue(t, ds.Type, "", ds.UID))
+}
+
+func TestNewRegistryFor_CountsOnItsRegisterer(t *testing.T) {
+	swapLogger(t)
+
+	reg := prometheus.NewRegistry()
+	r := NewRegistryFor(reg)
+	ds := backend.DataSourceInstanceSettings{Type: "registry-for", UID: "ds-uid-4"}
+
+	before := counterValue(t, ds.Type, "", ds.UID)
+	require.True(t, r.Observe(context.Background(),
+		Observation{Datasource: ds, Rows: DefaultRowsThreshold},
+		Thresholds{Rows: DefaultRowsThreshold}))
+
+	assert.Equal(t, before, counterValue(t, ds.Type, "", ds.UID), "expected the default counter to be untouched")
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	assert.Equal(t, "plugins_sql_large_responses_total", families[0].GetName())
+	require.Len(t, families[0].GetMetric(), 1)
+	assert.Equal(t, float64(1), families[0].GetMetric()[0].GetCounter().GetValue())
+}
+
 // counterValue reads the large-responses counter for a given label set.
 // Returns 0 if the series has not been created yet.
 func counterValue(t *testing.T, dsType, appURL, dsUID string) float64 {
@@ -0,0 +1,183 @@
+package sqlds
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// defaultResultCacheMaxBytes bounds the default result cache when
+// DriverSettings.ResultCacheMaxBytes is unset.
+const defaultResultCacheMaxBytes = int64(64 * 1024 * 1024)
+
+// ResultCache is the per-datasource cache contract for query results. Entries
+// are keyed by a digest of the interpolated SQL, the connection cache key, the
+// query args, the time range and the format (see resultCacheKey). Plugins
+// install a custom implementation (e.g. one backed by a shared store) via
+// SQLDatasource.ResultCacheFactory.
+//
+// Values are the frames produced by handleQuery, before the ResponseMutator
+// runs. Get MUST return frames the caller is free to mutate: an
+// implementation holding frames in memory has to hand out copies.
+//
+// Implementations MUST be safe for concurrent use from any number of
+// goroutines.
+type ResultCache interface {
+	// Get returns the frames stored under key, or (nil, false) if no live
+	// entry exists for the key.
+	Get(key string) (data.Frames, bool)
+	// Set stores frames under key, overwriting any prior value for key.
+	// Implementations may decline to store an entry (e.g. one larger than
+	// their size bound).
+	Set(key string, frames data.Frames)
+	// Dispose releases any resources held by the implementation and is
+	// invoked from SQLDatasource.Dispose.
+	Dispose()
+}
+
+// NewLRUResultCache returns the default ResultCache implementation: an
+// in-memory LRU bounded by maxBytes of serialized frames, whose entries
+// expire ttl after they were stored. Frames are held in Arrow encoding, which
+// both measures their size and gives every Get an independent copy.
+//
+// A maxBytes <= 0 resolves to 64 MiB. A ttl <= 0 disables expiry.
+func NewLRUResultCache(ttl time.Duration, maxBytes int64) ResultCache {
+	if maxBytes <= 0 {
+		maxBytes = defaultResultCacheMaxBytes
+	}
+	return &lruResultCache{
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+type lruResultEntry struct {
+	key     string
+	frames  [][]byte
+	size    int64
+	expires time.Time
+}
+
+// lruResultCache is the default ResultCache. order holds *lruResultEntry
+// values, most recently used at the front.
+type lruResultCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	maxBytes int64
+	size     int64
+	entries  map[string]*list.Element
+	order    *list.List
+	now      func() time.Time
+}
+
+func (c *lruResultCache) Get(key string) (data.Frames, bool) {
+	c.mu.Lock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil, false
+	}
+	entry := el.Value.(*lruResultEntry)
+	if c.ttl > 0 && !c.now().Before(entry.expires) {
+		c.remove(el)
+		c.mu.Unlock()
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	c.mu.Unlock()
+
+	frames, err := data.UnmarshalArrowFrames(entry.frames)
+	if err != nil {
+		backend.Logger.Warn("failed to decode cached result", "error", err.Error())
+		return nil, false
+	}
+	return frames, true
+}
+
+func (c *lruResultCache) Set(key string, frames data.Frames) {
+	encoded, err := frames.MarshalArrow()
+	if err != nil {
+		backend.Logger.Debug("skipping result cache store", "error", err.Error())
+		return
+	}
+	var size int64
+	for _, b := range encoded {
+		size += int64(len(b))
+	}
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.entries[key] = c.order.PushFront(&lruResultEntry{
+		key:     key,
+		frames:  encoded,
+		size:    size,
+		expires: c.now().Add(c.ttl),
+	})
+	c.size += size
+	for c.size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *lruResultCache) Dispose() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+	c.size = 0
+}
+
+// remove drops el from the cache. Callers must hold c.mu.
+func (c *lruResultCache) remove(el *list.Element) {
+	entry := c.order.Remove(el).(*lruResultEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
+
+// resultCacheKey digests everything that determines a query's result for a
+// given datasource, and keys the ResultCache: the SQL after interpolation,
+// the connection it runs on, the args set by QueryArgSetter, the time range,
+// the format, fill mode and row limit, and the RefID the frames are named
+// after.
+func resultCacheKey(q *Query, connKey string, fillMode *data.FillMissing, rowLimit int64, args []interface{}) string {
+	return queryDigest(q, connKey, fillMode, rowLimit, args, q.RefID)
+}
+
+// queryDigest digests the parts of a query keying its result. Each part is
+// length-prefixed so that no two distinct inputs share an encoding.
+func queryDigest(q *Query, connKey string, fillMode *data.FillMissing, rowLimit int64, args []interface{}, refID string) string {
+	fill := ""
+	if fillMode != nil {
+		fill = fmt.Sprintf("%d:%v", fillMode.Mode, fillMode.Value)
+	}
+	h := sha256.New()
+	for _, part := range []string{
+		q.RawSQL,
+		connKey,
+		fmt.Sprint(args...),
+		refID,
+		fill,
+		strconv.FormatInt(q.TimeRange.From.UnixNano(), 10),
+		strconv.FormatInt(q.TimeRange.To.UnixNano(), 10),
+		strconv.FormatUint(uint64(q.Format), 10),
+		strconv.FormatInt(rowLimit, 10),
+	} {
+		fmt.Fprintf(h, "%d:%s", len(part), part)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
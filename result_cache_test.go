@@ -0,0 +1,134 @@
+package sqlds
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func resultCacheTestFrames(values ...int64) data.Frames {
+	return data.Frames{data.NewFrame("A", data.NewField("v", nil, values))}
+}
+
+func TestLRUResultCache_GetReturnsIndependentCopies(t *testing.T) {
+	c := NewLRUResultCache(time.Minute, 0)
+	c.Set("k", resultCacheTestFrames(1, 2, 3))
+
+	first, ok := c.Get("k")
+	if !ok {
+		t.Fatal("expected hit")
+	}
+	first[0].Fields[0].Set(0, int64(42))
+	first[0].Name = "mutated"
+
+	second, ok := c.Get("k")
+	if !ok {
+		t.Fatal("expected hit")
+	}
+	if got := second[0].Fields[0].At(0).(int64); got != 1 {
+		t.Fatalf("cached value mutated through a previous Get: got %d want 1", got)
+	}
+	if second[0].Name != "A" {
+		t.Fatalf("cached frame name mutated through a previous Get: got %q", second[0].Name)
+	}
+}
+
+func TestLRUResultCache_TTLExpiry(t *testing.T) {
+	c := NewLRUResultCache(time.Minute, 0).(*lruResultCache)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	c.Set("k", resultCacheTestFrames(1))
+
+	now = now.Add(59 * time.Second)
+	if _, ok := c.Get("k"); !ok {
+		t.Fatal("expected hit before TTL")
+	}
+	now = now.Add(time.Second)
+	if _, ok := c.Get("k"); ok {
+		t.Fatal("expected miss once TTL elapsed")
+	}
+	if c.size != 0 || len(c.entries) != 0 {
+		t.Fatalf("expired entry not removed: size=%d entries=%d", c.size, len(c.entries))
+	}
+}
+
+func TestLRUResultCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	encoded, err := resultCacheTestFrames(1).MarshalArrow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entrySize := int64(len(encoded[0]))
+	c := NewLRUResultCache(0, 2*entrySize)
+
+	c.Set("a", resultCacheTestFrames(1))
+	c.Set("b", resultCacheTestFrames(2))
+	// Touch "a" so "b" becomes the least recently used entry.
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected hit on a")
+	}
+	c.Set("c", resultCacheTestFrames(3))
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("expected b to be evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if _, ok := c.Get(k); !ok {
+			t.Fatalf("expected %s to survive eviction", k)
+		}
+	}
+}
+
+func TestLRUResultCache_SkipsOversizedEntries(t *testing.T) {
+	c := NewLRUResultCache(0, 1)
+	c.Set("k", resultCacheTestFrames(1))
+	if _, ok := c.Get("k"); ok {
+		t.Fatal("expected entry larger than maxBytes not to be stored")
+	}
+}
+
+func TestLRUResultCache_Dispose(t *testing.T) {
+	c := NewLRUResultCache(0, 0)
+	c.Set("k", resultCacheTestFrames(1))
+	c.Dispose()
+	if _, ok := c.Get("k"); ok {
+		t.Fatal("expected miss after Dispose")
+	}
+}
+
+func TestResultCacheKey(t *testing.T) {
+	from := time.Unix(0, 0)
+	base := func() *Query {
+		return &Query{
+			RawSQL:    "SELECT 1",
+			RefID:     "A",
+			Format:    FormatOptionTable,
+			TimeRange: backend.TimeRange{From: from, To: from.Add(time.Hour)},
+		}
+	}
+	key := resultCacheKey(base(), "conn", nil, -1, nil)
+	if key != resultCacheKey(base(), "conn", nil, -1, nil) {
+		t.Fatal("expected identical queries to share a key")
+	}
+
+	sql, timeRange, format := base(), base(), base()
+	sql.RawSQL = "SELECT 2"
+	timeRange.TimeRange.To = from.Add(2 * time.Hour)
+	format.Format = FormatOptionTimeSeries
+
+	cases := map[string]string{
+		"sql":        resultCacheKey(sql, "conn", nil, -1, nil),
+		"connection": resultCacheKey(base(), "other", nil, -1, nil),
+		"args":       resultCacheKey(base(), "conn", nil, -1, []interface{}{"x"}),
+		"time range": resultCacheKey(timeRange, "conn", nil, -1, nil),
+		"format":     resultCacheKey(format, "conn", nil, -1, nil),
+		"row limit":  resultCacheKey(base(), "conn", nil, 10, nil),
+		"fill mode":  resultCacheKey(base(), "conn", &data.FillMissing{Mode: data.FillModeNull}, -1, nil),
+	}
+	for name, other := range cases {
+		if other == key {
+			t.Fatalf("expected a different %s to change the key", name)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package sqlds
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	defaultRetryMultiplier = 2.0
+	defaultRetryMaxDelay   = 30 * time.Second
+)
+
+// RetryPolicy decides how long to wait before each retry of a failed query or
+// connection attempt. DriverSettings.Retries still bounds the number of
+// retries; a policy can stop earlier.
+type RetryPolicy interface {
+	// NextDelay returns the delay before retry number attempt, starting at 1,
+	// given the time elapsed since the first failure. Returning false stops
+	// retrying.
+	NextDelay(attempt int, elapsed time.Duration) (time.Duration, bool)
+}
+
+// ExponentialBackoff is a RetryPolicy whose delay starts at InitialDelay and
+// grows by Multiplier with every attempt, up to MaxDelay. Each delay is
+// randomized to between half and all of its nominal value, so that replicas
+// failing at the same moment do not retry in lockstep.
+type ExponentialBackoff struct {
+	InitialDelay time.Duration
+	// Multiplier defaults to 2 when not greater than 1.
+	Multiplier float64
+	// MaxDelay caps the nominal delay. Zero or less means no cap.
+	MaxDelay time.Duration
+	// MaxElapsedTime stops retrying once waiting for the next retry would go
+	// past this much time since the first failure. Zero means no limit.
+	MaxElapsedTime time.Duration
+}
+
+// NextDelay implements RetryPolicy.
+func (b ExponentialBackoff) NextDelay(attempt int, elapsed time.Duration) (time.Duration, bool) {
+	multiplier := b.Multiplier
+	if multiplier <= 1 {
+		multiplier = defaultRetryMultiplier
+	}
+	maxDelay := b.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = math.MaxInt64
+	}
+
+	// The nominal delay is compared as a float so that growing past what a
+	// Duration holds clamps to the cap rather than overflowing.
+	var delay time.Duration
+	if b.InitialDelay > 0 {
+		delay = maxDelay
+		if nominal := float64(b.InitialDelay) * math.Pow(multiplier, float64(attempt-1)); nominal < float64(maxDelay) {
+			delay = time.Duration(nominal)
+		}
+	}
+	if half := delay / 2; half > 0 {
+		delay = half + rand.N(delay-half)
+	}
+
+	if b.MaxElapsedTime > 0 && delay > b.MaxElapsedTime-elapsed {
+		return 0, false
+	}
+	return delay, true
+}
+
+// retryPolicy returns the policy retries are paced by: RetryPolicy when set,
+// otherwise an ExponentialBackoff starting at Pause seconds. Without a Pause
+// the default policy retries immediately.
+func (s DriverSettings) retryPolicy() RetryPolicy {
+	if s.RetryPolicy != nil {
+		return s.RetryPolicy
+	}
+	return ExponentialBackoff{
+		InitialDelay: time.Duration(s.Pause) * time.Second,
+		Multiplier:   defaultRetryMultiplier,
+		MaxDelay:     defaultRetryMaxDelay,
+	}
+}
+
+// waitRetry waits the delay the policy asks for before retry number attempt
+// and then records the retry, for reason. It returns false, without waiting,
+// when the policy gives up, and stops waiting early, returning false without
+// recording the retry, when ctx is done.
+func waitRetry(ctx context.Context, policy RetryPolicy, metrics Metrics, reason RetryReason, attempt int, firstFailure time.Time) bool {
+	delay, ok := policy.NextDelay(attempt, time.Since(firstFailure))
+	if !ok {
+		return false
+	}
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+		}
+	}
+	metrics.CollectRetry(ctx, reason, attempt)
+	return true
+}
+
+// RetryDecision is how a failed query or connection attempt is retried.
+type RetryDecision int
+
+const (
+	// RetryDefault defers the decision to DriverSettings.RetryOn.
+	RetryDefault RetryDecision = iota
+	// RetryFail does not retry.
+	RetryFail
+	// RetryWithReconnect opens a new connection before retrying.
+	RetryWithReconnect
+	// RetryWithoutReconnect retries on the same connection.
+	RetryWithoutReconnect
+)
+
+// RetryClassifier is an additional interface that could be implemented by driver.
+// This adds the ability to the driver to decide which errors are retried by
+// inspecting them with errors.As for its own error types, rather than by
+// matching DriverSettings.RetryOn against the error text. Errors it returns
+// RetryDefault for fall back to RetryOn.
+type RetryClassifier interface {
+	ClassifyRetry(err error) RetryDecision
+}
+
+// classifyRetry decides how err is retried: by the driver's RetryClassifier
+// when it has an opinion, and otherwise by reconnecting if the error text
+// contains one of retryOn.
+func classifyRetry(classifier RetryClassifier, retryOn []string, err error) RetryDecision {
+	if classifier != nil {
+		if decision := classifier.ClassifyRetry(err); decision != RetryDefault {
+			return decision
+		}
+	}
+	if shouldRetry(retryOn, err.Error()) {
+		return RetryWithReconnect
+	}
+	return RetryFail
+}
@@ -0,0 +1,104 @@
+package sqlds
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestExponentialBackoff_NextDelay(t *testing.T) {
+	b := ExponentialBackoff{InitialDelay: time.Second, Multiplier: 2, MaxDelay: 5 * time.Second}
+	for attempt, nominal := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 5 * time.Second,
+		9: 5 * time.Second,
+	} {
+		for i := 0; i < 20; i++ {
+			delay, ok := b.NextDelay(attempt, 0)
+			if !ok {
+				t.Fatalf("attempt %d: expected to retry", attempt)
+			}
+			if delay < nominal/2 || delay > nominal {
+				t.Fatalf("attempt %d: delay %s outside [%s, %s]", attempt, delay, nominal/2, nominal)
+			}
+		}
+	}
+}
+
+func TestExponentialBackoff_MaxElapsedTime(t *testing.T) {
+	b := ExponentialBackoff{InitialDelay: time.Second, MaxElapsedTime: 10 * time.Second}
+	if _, ok := b.NextDelay(1, 0); !ok {
+		t.Fatal("expected to retry within the elapsed time budget")
+	}
+	if _, ok := b.NextDelay(2, 10*time.Second); ok {
+		t.Fatal("expected to stop once the elapsed time budget is spent")
+	}
+}
+
+func TestExponentialBackoff_Overflow(t *testing.T) {
+	uncapped := ExponentialBackoff{InitialDelay: time.Second}
+	for _, attempt := range []int{64, 100, 2000} {
+		delay, ok := uncapped.NextDelay(attempt, 0)
+		if !ok || delay < math.MaxInt64/2 {
+			t.Fatalf("attempt %d: expected the delay to clamp to the largest duration, got %s %v", attempt, delay, ok)
+		}
+	}
+
+	bounded := ExponentialBackoff{InitialDelay: time.Second, MaxElapsedTime: time.Minute}
+	if _, ok := bounded.NextDelay(100, time.Second); ok {
+		t.Fatal("expected a clamped delay past the elapsed time budget to stop retrying")
+	}
+}
+
+func TestDriverSettings_RetryPolicy(t *testing.T) {
+	if delay, ok := (DriverSettings{}).retryPolicy().NextDelay(3, 0); !ok || delay != 0 {
+		t.Fatalf("expected immediate retries without Pause, got %s %v", delay, ok)
+	}
+	delay, ok := (DriverSettings{Pause: 2}).retryPolicy().NextDelay(1, 0)
+	if !ok || delay < time.Second || delay > 2*time.Second {
+		t.Fatalf("expected the first delay to be based on Pause, got %s %v", delay, ok)
+	}
+
+	custom := ExponentialBackoff{InitialDelay: time.Minute}
+	if got := (DriverSettings{Pause: 2, RetryPolicy: custom}).retryPolicy(); got != custom {
+		t.Fatalf("expected the configured policy, got %#v", got)
+	}
+}
+
+type fixedDelay time.Duration
+
+func (d fixedDelay) NextDelay(int, time.Duration) (time.Duration, bool) {
+	return time.Duration(d), true
+}
+
+func TestWaitRetry(t *testing.T) {
+	metrics := NewMetrics("retry_test", "retry-test", EndpointQuery)
+	if !waitRetry(context.Background(), fixedDelay(time.Millisecond), metrics, RetryReasonError, 1, time.Now()) {
+		t.Fatal("expected to retry")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if waitRetry(ctx, fixedDelay(time.Hour), metrics, RetryReasonError, 2, time.Now()) {
+		t.Fatal("expected a canceled context to stop retrying")
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("expected waiting to stop when the context is canceled")
+	}
+
+	for attempt, want := range map[string]float64{"1": 1, "2": 0} {
+		m := &dto.Metric{}
+		if err := retryMetric.WithLabelValues("retry_test", "retry-test", string(EndpointQuery), string(RetryReasonError), attempt).Write(m); err != nil {
+			t.Fatal(err)
+		}
+		if got := m.GetCounter().GetValue(); got != want {
+			t.Fatalf("attempt %s: expected %v recorded retries, got %v", attempt, want, got)
+		}
+	}
+}
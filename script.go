@@ -0,0 +1,254 @@
+package sqlds
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+)
+
+// RunScript runs statements in order on a single connection and converts the
+// results to dataframes. Statements share one *sql.Conn pinned from the
+// underlying *sql.DB, so session state such as variables and temporary
+// tables set up by earlier statements is visible to later ones. args are
+// passed only to the statements containing a positional placeholder, such as
+// ? or $1, or to every statement when they include a sql.NamedArg.
+//
+// Only the frames of the final statement are returned. When allResults is
+// set, the frames of every statement that returned columns are returned
+// instead, named RefID-<statement index>. The row limit applies to the rows
+// of the script as a whole. Execution stops at the first failing statement.
+func (q *DBQuery) RunScript(ctx context.Context, query *Query, statements []string, allResults bool, queryErrorMutator QueryErrorMutator, args ...interface{}) (data.Frames, error) {
+	start := time.Now()
+
+	db, release, err := q.pin(ctx)
+	if err != nil {
+		q.metrics.CollectDuration(SourceDownstream, StatusError, time.Since(start).Seconds())
+		return sqlutil.ErrorFrameFromQuery(query), err
+	}
+	defer release()
+
+	var (
+		res       data.Frames
+		noResults error
+		budget    = newRowBudget(q.rowLimit)
+	)
+	for i, statement := range statements {
+		last := i == len(statements)-1
+		stmtQuery := *query
+		stmtQuery.RawSQL = statement
+		if allResults {
+			stmtQuery.RefID = fmt.Sprintf("%s-%d", query.RefID, i)
+		}
+
+		frames, err := q.runStatement(ctx, db, &stmtQuery, budget, last, allResults, queryErrorMutator, q.statementArgs(statement, args)...)
+		if errors.Is(err, ErrorNoResults) && allResults {
+			noResults = err
+			continue
+		}
+		if err != nil {
+			return sqlutil.ErrorFrameFromQuery(query), err
+		}
+		res = append(res, frames...)
+	}
+
+	if len(res) == 0 && noResults != nil {
+		return sqlutil.ErrorFrameFromQuery(query), noResults
+	}
+	q.observeResponseSize(ctx, res, query, start)
+	return res, nil
+}
+
+// runStatement runs a single statement of a script on db, reading at most
+// the rows left in budget. The rows of statements before the last are
+// discarded unless allResults is set, and even then statements that return
+// no columns, such as SET or CREATE, produce no frames.
+func (q *DBQuery) runStatement(ctx context.Context, db Connection, query *Query, budget *rowBudget, last, allResults bool, queryErrorMutator QueryErrorMutator, args ...interface{}) (data.Frames, error) {
+	rows, err := q.queryRows(ctx, db, query, queryErrorMutator, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			backend.Logger.Error(err.Error())
+		}
+	}()
+
+	keep, err := keepsRows(rows, last, allResults)
+	if err != nil {
+		return nil, err
+	}
+	if keep {
+		return q.framesFromRows(ctx, rows, query, budget, queryErrorMutator)
+	}
+	return nil, drainRows(rows)
+}
+
+// StreamScript runs statements in order on a single connection, as RunScript
+// does, and streams the rows of the final statement, or with allResults of
+// every statement returning columns, as Stream does. The frames of each
+// statement are named as RunScript names them, and the row limit applies to
+// the script as a whole, as it does for RunScript.
+func (q *DBQuery) StreamScript(ctx context.Context, query *Query, statements []string, allResults bool, batchSize int64, queryErrorMutator QueryErrorMutator, send func(*data.Frame) error, args ...interface{}) error {
+	start := time.Now()
+
+	db, release, err := q.pin(ctx)
+	if err != nil {
+		q.metrics.CollectDuration(SourceDownstream, StatusError, time.Since(start).Seconds())
+		return err
+	}
+	defer release()
+
+	s := q.newFrameStream(query, batchSize, send)
+	for i, statement := range statements {
+		last := i == len(statements)-1
+		stmtQuery := *query
+		stmtQuery.RawSQL = statement
+		if allResults {
+			stmtQuery.RefID = fmt.Sprintf("%s-%d", query.RefID, i)
+		}
+
+		if err := q.streamStatement(ctx, db, s, &stmtQuery, last, allResults, queryErrorMutator, q.statementArgs(statement, args)...); err != nil {
+			return err
+		}
+	}
+	s.observe(ctx, query, start)
+	return nil
+}
+
+// streamStatement runs a single statement of a streamed script on db,
+// keeping or discarding its rows as runStatement does.
+func (q *DBQuery) streamStatement(ctx context.Context, db Connection, s *frameStream, query *Query, last, allResults bool, queryErrorMutator QueryErrorMutator, args ...interface{}) error {
+	rows, err := q.queryRows(ctx, db, query, queryErrorMutator, args...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			backend.Logger.Error(err.Error())
+		}
+	}()
+
+	keep, err := keepsRows(rows, last, allResults)
+	if err != nil {
+		return err
+	}
+	if keep {
+		return s.stream(ctx, rows, query, queryErrorMutator)
+	}
+	return drainRows(rows)
+}
+
+// statementArgs returns the args passed to statement of a script: all of them
+// when it contains a positional placeholder such as ? or $1, and none
+// otherwise, so statements taking no arguments, such as SET or CREATE, do not
+// fail on those meant for the others. Which statements use named arguments
+// cannot be told from their text, so when args include a sql.NamedArg every
+// statement gets all of them.
+func (q *DBQuery) statementArgs(statement string, args []interface{}) []interface{} {
+	for _, arg := range args {
+		if _, ok := arg.(sql.NamedArg); ok {
+			return args
+		}
+	}
+	for _, token := range lexSQL(statement, q.backslashEscapes) {
+		if token.kind == sqlParam {
+			return args
+		}
+	}
+	return nil
+}
+
+// pin returns the connection the statements of a script share: a *sql.Conn
+// pinned from q.DB when it is a *sql.DB, which release returns to the pool.
+func (q *DBQuery) pin(ctx context.Context) (db Connection, release func(), err error) {
+	sqlDB, ok := q.DB.(*sql.DB)
+	if !ok {
+		return q.DB, func() {}, nil
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, nil, backend.DownstreamError(fmt.Errorf("%w: %w", ErrorQuery, err))
+	}
+	return pinnedConn{conn}, func() {
+		if err := conn.Close(); err != nil {
+			backend.Logger.Error(err.Error())
+		}
+	}, nil
+}
+
+// keepsRows reports whether the rows of a script statement are returned: those
+// of the last statement, and with allResults those of every statement
+// returning columns.
+func keepsRows(rows *sql.Rows, last, allResults bool) (bool, error) {
+	if last {
+		return true, nil
+	}
+	if !allResults {
+		return false, nil
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return false, backend.DownstreamError(fmt.Errorf("%w: %w", ErrorQuery, err))
+	}
+	return len(columns) > 0, nil
+}
+
+// drainRows discards the rows of a statement so errors raised while it runs
+// are reported.
+func drainRows(rows *sql.Rows) error {
+	for rows.Next() {
+	}
+	if err := rows.Err(); err != nil {
+		return backend.DownstreamError(fmt.Errorf("%w: %w", ErrorQuery, err))
+	}
+	return nil
+}
+
+// pinnedConn adapts a *sql.Conn to the Connection interface so a script's
+// statements can be run through DBQuery on the same connection.
+type pinnedConn struct {
+	*sql.Conn
+}
+
+func (c pinnedConn) Ping() error {
+	return c.PingContext(context.Background())
+}
+
+// splitStatements splits a SQL script into its statements on top-level
+// semicolons, as lexSQL tokenizes it: semicolons inside quoted strings and
+// identifiers, PostgreSQL dollar-quoted strings and comments do not end a
+// statement. backslashEscapes is passed to lexSQL. Statements are trimmed of
+// surrounding whitespace, and statements made up only of whitespace and
+// comments are dropped.
+func splitStatements(script string, backslashEscapes bool) []string {
+	var (
+		statements []string
+		start, pos int
+		hasCode    bool
+	)
+	flush := func(end int) {
+		if hasCode {
+			statements = append(statements, strings.TrimSpace(script[start:end]))
+		}
+		start, hasCode = end+1, false
+	}
+
+	for _, token := range lexSQL(script, backslashEscapes) {
+		switch {
+		case token.kind == sqlPunct && token.text == ";":
+			flush(pos)
+		case token.kind != sqlSpace && token.kind != sqlComment:
+			hasCode = true
+		}
+		pos += len(token.text)
+	}
+	flush(len(script))
+	return statements
+}
@@ -0,0 +1,79 @@
+package sqlds
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name             string
+		script           string
+		backslashEscapes bool
+		want             []string
+	}{
+		{name: "single statement", script: "SELECT 1", want: []string{"SELECT 1"}},
+		{name: "trailing semicolon", script: "SELECT 1;", want: []string{"SELECT 1"}},
+		{
+			name:   "several statements",
+			script: "SET @x = 1;\nCREATE TEMPORARY TABLE t (v INT);\n  SELECT * FROM t ;",
+			want:   []string{"SET @x = 1", "CREATE TEMPORARY TABLE t (v INT)", "SELECT * FROM t"},
+		},
+		{name: "empty statements", script: ";; SELECT 1 ;  ;", want: []string{"SELECT 1"}},
+		{name: "single quotes", script: "SELECT 'a;b'; SELECT 2", want: []string{"SELECT 'a;b'", "SELECT 2"}},
+		{name: "escaped quote", script: "SELECT 'it''s;'; SELECT 2", want: []string{"SELECT 'it''s;'", "SELECT 2"}},
+		{name: "double quotes", script: `SELECT "a;b" FROM t; SELECT 2`, want: []string{`SELECT "a;b" FROM t`, "SELECT 2"}},
+		{name: "backticks", script: "SELECT `a;b` FROM t; SELECT 2", want: []string{"SELECT `a;b` FROM t", "SELECT 2"}},
+		{name: "line comment", script: "SELECT 1 -- not; a split\n; SELECT 2", want: []string{"SELECT 1 -- not; a split", "SELECT 2"}},
+		{name: "block comment", script: "SELECT /* ; */ 1; SELECT 2", want: []string{"SELECT /* ; */ 1", "SELECT 2"}},
+		{name: "comment only statement", script: "SELECT 1; -- done\n/* bye; */", want: []string{"SELECT 1"}},
+		{
+			name:   "dollar quotes",
+			script: "CREATE FUNCTION f() RETURNS int AS $body$ BEGIN; RETURN 1; END; $body$ LANGUAGE plpgsql; SELECT f()",
+			want:   []string{"CREATE FUNCTION f() RETURNS int AS $body$ BEGIN; RETURN 1; END; $body$ LANGUAGE plpgsql", "SELECT f()"},
+		},
+		{name: "anonymous dollar quotes", script: "DO $$ BEGIN; END $$; SELECT 2", want: []string{"DO $$ BEGIN; END $$", "SELECT 2"}},
+		{name: "positional parameters", script: "SELECT $1; SELECT $2", want: []string{"SELECT $1", "SELECT $2"}},
+		{name: "unterminated quote", script: "SELECT 'a; SELECT 2", want: []string{"SELECT 'a; SELECT 2"}},
+		{name: "empty", script: "  ", want: nil},
+		{name: "doubled quote in identifier", script: `SELECT "a"";b"; SELECT 2`, want: []string{`SELECT "a"";b"`, "SELECT 2"}},
+		{name: "backslash without escapes", script: `SELECT 'C:\'; SELECT 2`, want: []string{`SELECT 'C:\'`, "SELECT 2"}},
+		{name: "backslash escaped quote", script: `SELECT 'a\';b'; SELECT 2`, backslashEscapes: true, want: []string{`SELECT 'a\';b'`, "SELECT 2"}},
+		{name: "escaped backslash", script: `SELECT 'a\\'; SELECT 2`, backslashEscapes: true, want: []string{`SELECT 'a\\'`, "SELECT 2"}},
+		{name: "backslash in backticks", script: "SELECT `a\\`; SELECT 2", backslashEscapes: true, want: []string{"SELECT `a\\`", "SELECT 2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, splitStatements(tt.script, tt.backslashEscapes))
+		})
+	}
+}
+
+func TestStatementArgs(t *testing.T) {
+	args := []interface{}{1, "a"}
+	named := []interface{}{sql.Named("x", 1)}
+	tests := []struct {
+		name             string
+		statement        string
+		args             []interface{}
+		backslashEscapes bool
+		want             []interface{}
+	}{
+		{name: "question mark", statement: "SELECT * FROM t WHERE a = ? AND b = ?", args: args, want: args},
+		{name: "dollar placeholder", statement: "SELECT $1", args: args, want: args},
+		{name: "no placeholder", statement: "SET @x = 1", args: args, want: nil},
+		{name: "placeholder in string", statement: "SELECT 'a?b', '$1'", args: args, want: nil},
+		{name: "placeholder in comment", statement: "CREATE TABLE t (v INT) -- why?", args: args, want: nil},
+		{name: "backslash escaped quote", statement: `SELECT 'it\'s?'`, args: args, backslashEscapes: true, want: nil},
+		{name: "named arguments", statement: "SET NOCOUNT ON", args: named, want: named},
+		{name: "no arguments", statement: "SELECT ?", args: nil, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := (&DBQuery{}).WithBackslashEscapes(tt.backslashEscapes)
+			assert.Equal(t, tt.want, q.statementArgs(tt.statement, tt.args))
+		})
+	}
+}
@@ -0,0 +1,390 @@
+package sqlds
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// defaultStreamBatchSize is the number of rows sent per frame when
+// DriverSettings.StreamBatchSize is unset.
+const defaultStreamBatchSize = int64(10_000)
+
+// streamPathPrefix is the channel path prefix served by RunStream. Frontends
+// subscribe to ds/<uid>/query/<refID> and pass the query model, plus its time
+// range, as the subscription data.
+const streamPathPrefix = "query/"
+
+var (
+	// ErrorStreamingDisabled is returned when a stream is requested but SQLDatasource.EnableStreaming is false
+	ErrorStreamingDisabled = backend.PluginError(errors.New("streaming is not enabled for this datasource"))
+	// ErrorStreamPath is returned when a stream is requested on a path sqlds does not serve
+	ErrorStreamPath = errors.New("unsupported stream path")
+)
+
+// streamQuery is the subscription payload for a streamed query. It mirrors the
+// fields of backend.DataQuery that the frontend serialises with every query;
+// the payload as a whole is also handed to GetQuery and the Interpolator as
+// the raw query JSON, so plugin-defined fields (rawSql, format, …) sit next
+// to these.
+type streamQuery struct {
+	RefID         string `json:"refId"`
+	QueryType     string `json:"queryType"`
+	MaxDataPoints int64  `json:"maxDataPoints"`
+	IntervalMS    int64  `json:"intervalMs"`
+	TimeRange     struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"timeRange"`
+}
+
+// dataQueryFromStream converts a subscription payload to the backend.DataQuery
+// consumed by the rest of the query pipeline.
+func dataQueryFromStream(raw json.RawMessage) (backend.DataQuery, error) {
+	var sq streamQuery
+	if err := json.Unmarshal(raw, &sq); err != nil {
+		return backend.DataQuery{}, backend.DownstreamError(fmt.Errorf("%w: %w", ErrorJSON, err))
+	}
+	return backend.DataQuery{
+		RefID:         sq.RefID,
+		QueryType:     sq.QueryType,
+		MaxDataPoints: sq.MaxDataPoints,
+		Interval:      time.Duration(sq.IntervalMS) * time.Millisecond,
+		TimeRange:     backend.TimeRange{From: sq.TimeRange.From, To: sq.TimeRange.To},
+		JSON:          raw,
+	}, nil
+}
+
+// SubscribeStream allows subscriptions on query/* paths when streaming is enabled.
+func (ds *SQLDatasource) SubscribeStream(_ context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	if !ds.EnableStreaming || !strings.HasPrefix(req.Path, streamPathPrefix) {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+	return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusOK}, nil
+}
+
+// PublishStream rejects every publish; query streams are read-only.
+func (ds *SQLDatasource) PublishStream(_ context.Context, _ *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusPermissionDenied}, nil
+}
+
+// RunStream executes the query carried in req.Data and sends its rows as a
+// sequence of frames of at most DriverSettings.StreamBatchSize rows each. The
+// first frame carries the schema; later frames carry data only, except for
+// one carrying the row limit notice. Row limit, converters, multi-statement
+// scripts and response observation apply as they do for QueryData, but the
+// long-to-wide conversion for time series formats does not: streamed frames
+// always keep the shape of the result set.
+func (ds *SQLDatasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	if !ds.EnableStreaming {
+		return ErrorStreamingDisabled
+	}
+	if !strings.HasPrefix(req.Path, streamPathPrefix) {
+		return backend.DownstreamError(fmt.Errorf("%w: %s", ErrorStreamPath, req.Path))
+	}
+
+	query, err := dataQueryFromStream(req.Data)
+	if err != nil {
+		return err
+	}
+
+	headers := req.GetHTTPHeaders()
+	settings := ds.DriverSettings()
+
+	if ds.queryMutator != nil {
+		mctx, span := startSpan(ctx, "QueryMutator", attributeRefID.String(query.RefID))
+		mctx, query = ds.queryMutator.MutateQuery(mctx, query)
+		ctx = endMutatorSpan(ctx, mctx, span)
+	}
+
+	_, span := startSpan(ctx, "GetQuery", attributeRefID.String(query.RefID))
+	q, err := GetQuery(query, headers, settings.ForwardHeaders)
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+
+	q.RawSQL, err = ds.interpolateQuery(ctx, q, query.JSON)
+	if err != nil {
+		return interpolationError(err)
+	}
+
+	limits := ds.resolveQueryLimits(settings, query.JSON)
+
+	cacheKey, dbConn, err := ds.getConnection(ctx, q)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Release()
+
+	if limits.timeout != 0 {
+		tctx, cancel := context.WithTimeout(ctx, limits.timeout)
+		defer cancel()
+
+		ctx = tctx
+	}
+
+	ctx, done := ds.trackQuery(ctx, q, cacheKey, dbConn)
+	defer done()
+
+	var args []interface{}
+	if ds.queryArgSetter != nil {
+		args = ds.queryArgSetter.SetQueryArgs(ctx, headers)
+	}
+
+	release, err := ds.limiter.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer ds.metrics.TrackInFlight()()
+
+	batchSize := settings.StreamBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultStreamBatchSize
+	}
+
+	// Notices live in the frame meta, which is part of the schema, so the
+	// frame carrying the row limit notice is resent with its schema too, as
+	// is the first frame of each statement of a script.
+	var (
+		sent bool
+		name string
+		rows int64
+	)
+	send := func(frame *data.Frame) error {
+		include := data.IncludeDataOnly
+		if !sent || frame.Name != name || len(frame.Meta.Notices) > 0 {
+			include = data.IncludeAll
+		}
+		sent, name = true, frame.Name
+		rows += int64(frame.Rows())
+		return sender.SendFrame(frame, include)
+	}
+
+	var statements []string
+	if ds.EnableMultiStatement {
+		statements = splitStatements(q.RawSQL, settings.BackslashEscapes)
+	}
+	dbQuery := ds.newDBQuery(dbConn.db, dbConn.settings, nil, limits.rowLimit)
+	start := time.Now()
+	if len(statements) > 1 {
+		err = dbQuery.StreamScript(ctx, q, statements, ds.MultiStatementAllResults, batchSize, ds.queryErrorMutator, send, args...)
+	} else {
+		err = dbQuery.Stream(ctx, q, batchSize, ds.queryErrorMutator, send, args...)
+	}
+	ds.connector.recordQuery(cacheKey, err)
+	ds.auditQuery(ctx, q, cacheKey, AuditSourceDatabase, start, rows, 0, err)
+	return err
+}
+
+// Stream sends the query to the connection and hands the rows to send in
+// frames of at most batchSize rows. At least one frame is always sent, so a
+// query without rows still delivers its schema. Every result set of the query
+// is streamed; as their number is not known until the first one has been
+// sent, the frames of the first keep the query's RefID and those of later
+// sets are named RefID-<index>. The row limit is applied across all batches
+// and result sets, and the limit notice is attached to the last frame. Rows
+// are read and converted as Run reads them, and errors are classified as Run
+// classifies them; errors returned by send are returned as is.
+func (q *DBQuery) Stream(ctx context.Context, query *Query, batchSize int64, queryErrorMutator QueryErrorMutator, send func(*data.Frame) error, args ...interface{}) error {
+	start := time.Now()
+	rows, err := q.queryRows(ctx, q.DB, query, queryErrorMutator, args...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			backend.Logger.Error(err.Error())
+		}
+	}()
+
+	s := q.newFrameStream(query, batchSize, send)
+	if err := s.stream(ctx, rows, query, queryErrorMutator); err != nil {
+		return err
+	}
+	s.observe(ctx, query, start)
+	return nil
+}
+
+// frameStream sends the rows of a streamed query, or of the statements of a
+// streamed script, in frames of at most batchSize rows, keeping the response
+// totals.
+type frameStream struct {
+	q         *DBQuery
+	batchSize int64
+	queryHash string
+	send      func(*data.Frame) error
+	// budget is the row limit, shared by the statements of a script.
+	budget *rowBudget
+	// sendErr is the error send returned, which stops the stream.
+	sendErr error
+
+	rows, cells, bytes int64
+}
+
+func (q *DBQuery) newFrameStream(query *Query, batchSize int64, send func(*data.Frame) error) *frameStream {
+	return &frameStream{
+		q:         q,
+		batchSize: batchSize,
+		queryHash: QueryFingerprint(query.RawSQL),
+		send:      send,
+		budget:    newRowBudget(q.rowLimit),
+	}
+}
+
+// stream reads rows, the result of query, sending them in batches.
+func (s *frameStream) stream(ctx context.Context, rows *sql.Rows, query *Query, queryErrorMutator QueryErrorMutator) error {
+	err := s.q.convertRows(ctx, query, queryErrorMutator, func() (int64, error) {
+		if err := validateRows(rows); err != nil {
+			return 0, err
+		}
+		n, err := s.read(rows, query)
+		if s.sendErr != nil {
+			return n, nil
+		}
+		return n, err
+	})
+	if s.sendErr != nil {
+		return s.sendErr
+	}
+	return err
+}
+
+// read reads the rows of every result set of rows into batches, stopping at
+// the row limit, and returns the number of rows read.
+func (s *frameStream) read(rows *sql.Rows, query *Query) (int64, error) {
+	// The SDK's dynamic framer infers the field types from the data, so it
+	// reads every row before the first batch can be sent.
+	if hasDynamicConverter(s.q.converters) {
+		sets, err := readResultSets(rows, s.budget, 0, s.q.converters)
+		if err != nil {
+			return 0, err
+		}
+		return int64(sets[0].Rows()), s.sendBatches(sets[0], query)
+	}
+
+	var total int64
+	for set := 0; ; set++ {
+		setQuery := query
+		if set > 0 {
+			named := *query
+			named.RefID = fmt.Sprintf("%s-%d", query.RefID, set)
+			setQuery = &named
+		}
+		n, err := s.readResultSet(rows, setQuery)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if s.budget.remaining <= 0 || !rows.NextResultSet() {
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return total, backend.DownstreamError(err)
+	}
+	return total, nil
+}
+
+// readResultSet reads the rows of the current result set of rows into
+// batches, stopping at the row limit, and returns the number of rows read.
+func (s *frameStream) readResultSet(rows *sql.Rows, query *Query) (int64, error) {
+	reader, err := newResultSetReader(rows, s.q.converters)
+	if err != nil {
+		return 0, err
+	}
+	frame := reader.newFrame()
+	var total int64
+	for s.budget.remaining > 0 {
+		ok, err := reader.next(frame)
+		if err != nil {
+			return total, err
+		}
+		if !ok {
+			break
+		}
+		total++
+		s.budget.remaining--
+
+		if s.budget.remaining == 0 {
+			frame.AppendNotices(s.budget.notice())
+			break
+		}
+		if int64(frame.Rows()) == s.batchSize {
+			if err := s.flush(frame, query); err != nil {
+				return total, err
+			}
+			frame = reader.newFrame()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return total, backend.DownstreamError(err)
+	}
+
+	// Flush the trailing partial batch. When every row has already been
+	// sent this only happens if nothing was sent at all, so the subscriber
+	// still learns the schema of an empty result.
+	if frame.Rows() > 0 || total == 0 {
+		return total, s.flush(frame, query)
+	}
+	return total, nil
+}
+
+// sendBatches splits frame, read whole, into batches, attaching its notices
+// to the last one.
+func (s *frameStream) sendBatches(frame *data.Frame, query *Query) error {
+	var notices []data.Notice
+	if frame.Meta != nil {
+		notices = frame.Meta.Notices
+	}
+	n := frame.Rows()
+	for start := 0; ; start += int(s.batchSize) {
+		end := min(start+int(s.batchSize), n)
+		batch := frame.EmptyCopy()
+		for i := start; i < end; i++ {
+			batch.AppendRow(frame.RowCopy(i)...)
+		}
+		if end == n {
+			batch.AppendNotices(notices...)
+			return s.flush(batch, query)
+		}
+		if err := s.flush(batch, query); err != nil {
+			return err
+		}
+	}
+}
+
+// flush sends frame, one batch of the rows of query, and adds it to the
+// response totals.
+func (s *frameStream) flush(frame *data.Frame, query *Query) error {
+	frame.Name = query.RefID
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+	frame.Meta.ExecutedQueryString = query.RawSQL
+	frame.Meta.PreferredVisualization = preferredVisualization(query.Format)
+	setFrameQueryHash(frame, s.queryHash)
+
+	rows := int64(frame.Rows())
+	s.rows += rows
+	s.cells += rows * int64(len(frame.Fields))
+	s.bytes += s.q.frameBytes(frame)
+	if err := s.send(frame); err != nil {
+		s.sendErr = err
+		return err
+	}
+	return nil
+}
+
+// observe records the response totals of the stream of query.
+func (s *frameStream) observe(ctx context.Context, query *Query, start time.Time) {
+	s.q.observe(ctx, s.rows, s.cells, s.bytes, query.RefID, s.queryHash, start)
+}
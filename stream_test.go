@@ -0,0 +1,293 @@
+package sqlds_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/grafana/sqlds/v5"
+	"github.com/grafana/sqlds/v5/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStreamSender decodes every packet sent to the stream back into a frame.
+// Data-only packets are decoded against the schema of the first packet, the
+// way a Grafana Live subscriber reassembles them.
+type fakeStreamSender struct {
+	schema json.RawMessage
+	frames []*data.Frame
+}
+
+func (f *fakeStreamSender) Send(p *backend.StreamPacket) error {
+	var packet map[string]json.RawMessage
+	if err := json.Unmarshal(p.Data, &packet); err != nil {
+		return err
+	}
+	if schema, ok := packet["schema"]; ok {
+		f.schema = schema
+	}
+	// Frame JSON must list the schema before the data.
+	raw := fmt.Sprintf(`{"schema":%s,"data":%s}`, f.schema, packet["data"])
+	frame := &data.Frame{}
+	if err := json.Unmarshal([]byte(raw), frame); err != nil {
+		return err
+	}
+	f.frames = append(f.frames, frame)
+	return nil
+}
+
+// convertingDriver is a test driver with converters.
+type convertingDriver struct {
+	test.TestDS
+	converters []sqlutil.Converter
+}
+
+func (d convertingDriver) Converters() []sqlutil.Converter {
+	return d.converters
+}
+
+func streamDatasource(t *testing.T, name string, rows int, cfg string, converters ...sqlutil.Converter) *sqlds.SQLDatasource {
+	t.Helper()
+	testData := test.Data{
+		Cols: []test.Column{
+			{Name: "id", DataType: "INTEGER", Kind: int64(0)},
+			{Name: "name", DataType: "TEXT", Kind: ""},
+		},
+	}
+	for i := 0; i < rows; i++ {
+		testData.Rows = append(testData.Rows, []any{int64(i), "row"})
+	}
+
+	driver, _ := test.NewDriver(name, testData, nil, test.DriverOpts{}, nil)
+	ds := sqlds.NewDatasource(convertingDriver{driver, converters})
+	ds.EnableStreaming = true
+
+	settings := backend.DataSourceInstanceSettings{UID: name, JSONData: []byte(cfg)}
+	_, err := ds.NewDatasource(context.Background(), settings)
+	require.NoError(t, err)
+	return ds
+}
+
+func TestRunStream_SendsBatches(t *testing.T) {
+	ds := streamDatasource(t, "stream-batches", 5, `{ "streamBatchSize": 2 }`)
+	fake := &fakeStreamSender{}
+
+	err := ds.RunStream(context.Background(), &backend.RunStreamRequest{
+		Path: "query/A",
+		Data: []byte(`{ "refId": "A", "rawSql": "SELECT * FROM test", "format": "logs" }`),
+	}, backend.NewStreamSender(fake))
+	require.NoError(t, err)
+
+	require.Len(t, fake.frames, 3)
+	for i, want := range []int{2, 2, 1} {
+		assert.Equal(t, want, fake.frames[i].Rows(), "frame %d", i)
+	}
+	first := fake.frames[0]
+	assert.Equal(t, "A", first.Name)
+	require.NotNil(t, first.Meta)
+	assert.Equal(t, data.VisType(data.VisTypeLogs), first.Meta.PreferredVisualization)
+	assert.Equal(t, "SELECT * FROM test", first.Meta.ExecutedQueryString)
+}
+
+func TestRunStream_RowLimitAcrossBatches(t *testing.T) {
+	ds := streamDatasource(t, "stream-rowlimit", 5, `{ "streamBatchSize": 2 }`)
+	ds.SetDefaultRowLimit(3)
+	fake := &fakeStreamSender{}
+
+	err := ds.RunStream(context.Background(), &backend.RunStreamRequest{
+		Path: "query/A",
+		Data: []byte(`{ "refId": "A", "rawSql": "SELECT * FROM test" }`),
+	}, backend.NewStreamSender(fake))
+	require.NoError(t, err)
+
+	require.Len(t, fake.frames, 2)
+	assert.Equal(t, 2, fake.frames[0].Rows())
+	assert.Equal(t, 1, fake.frames[1].Rows())
+	require.NotNil(t, fake.frames[1].Meta)
+	require.Len(t, fake.frames[1].Meta.Notices, 1)
+	assert.Contains(t, fake.frames[1].Meta.Notices[0].Text, "limited to 3")
+}
+
+func TestRunStream_EmptyResultSendsSchema(t *testing.T) {
+	ds := streamDatasource(t, "stream-empty", 0, `{}`)
+	fake := &fakeStreamSender{}
+
+	err := ds.RunStream(context.Background(), &backend.RunStreamRequest{
+		Path: "query/A",
+		Data: []byte(`{ "refId": "A", "rawSql": "SELECT * FROM test" }`),
+	}, backend.NewStreamSender(fake))
+	require.NoError(t, err)
+
+	require.Len(t, fake.frames, 1)
+	assert.Equal(t, 0, fake.frames[0].Rows())
+	assert.Len(t, fake.frames[0].Fields, 2)
+}
+
+func TestRunStream_MultiStatement(t *testing.T) {
+	ds := streamDatasource(t, "stream-multi-statement", 3, `{ "streamBatchSize": 2 }`)
+	ds.EnableMultiStatement = true
+	ds.MultiStatementAllResults = true
+	fake := &fakeStreamSender{}
+
+	err := ds.RunStream(context.Background(), &backend.RunStreamRequest{
+		Path: "query/A",
+		Data: []byte(`{ "refId": "A", "rawSql": "SELECT 1; SELECT * FROM test" }`),
+	}, backend.NewStreamSender(fake))
+	require.NoError(t, err)
+
+	require.Len(t, fake.frames, 4)
+	for i, want := range []string{"A-0", "A-0", "A-1", "A-1"} {
+		assert.Equal(t, want, fake.frames[i].Name, "frame %d", i)
+	}
+	assert.Equal(t, "SELECT * FROM test", fake.frames[2].Meta.ExecutedQueryString)
+	assert.Equal(t, 1, fake.frames[3].Rows())
+}
+
+func TestRunStream_MultiStatementRowLimit(t *testing.T) {
+	ds := streamDatasource(t, "stream-multi-statement-rowlimit", 3, `{ "streamBatchSize": 2 }`)
+	ds.EnableMultiStatement = true
+	ds.MultiStatementAllResults = true
+	ds.SetDefaultRowLimit(4)
+	fake := &fakeStreamSender{}
+
+	err := ds.RunStream(context.Background(), &backend.RunStreamRequest{
+		Path: "query/A",
+		Data: []byte(`{ "refId": "A", "rawSql": "SELECT 1; SELECT * FROM test" }`),
+	}, backend.NewStreamSender(fake))
+	require.NoError(t, err)
+
+	require.Len(t, fake.frames, 3)
+	for i, want := range []struct {
+		name string
+		rows int
+	}{{"A-0", 2}, {"A-0", 1}, {"A-1", 1}} {
+		assert.Equal(t, want.name, fake.frames[i].Name, "frame %d", i)
+		assert.Equal(t, want.rows, fake.frames[i].Rows(), "frame %d", i)
+	}
+	require.Len(t, fake.frames[2].Meta.Notices, 1)
+	assert.Contains(t, fake.frames[2].Meta.Notices[0].Text, "limited to 4")
+}
+
+func TestRunStream_MultipleResultSets(t *testing.T) {
+	detail := test.Data{
+		Cols: []test.Column{{Name: "amount", DataType: "FLOAT", Kind: float64(0)}},
+		Rows: [][]any{{1.5}, {2.5}, {3.5}},
+	}
+	stream := func(t *testing.T, name string, rowLimit int64) []*data.Frame {
+		t.Helper()
+		testData := test.Data{
+			Cols: []test.Column{{Name: "id", DataType: "INTEGER", Kind: int64(0)}},
+			Rows: [][]any{{int64(1)}, {int64(2)}},
+		}
+		driver, _ := test.NewDriver(name, testData, nil, test.DriverOpts{ResultSets: []test.Data{detail}}, nil)
+		ds := sqlds.NewDatasource(driver)
+		ds.EnableStreaming = true
+		settings := backend.DataSourceInstanceSettings{UID: name, JSONData: []byte(`{ "streamBatchSize": 2 }`)}
+		_, err := ds.NewDatasource(context.Background(), settings)
+		require.NoError(t, err)
+		ds.SetDefaultRowLimit(rowLimit)
+
+		fake := &fakeStreamSender{}
+		err = ds.RunStream(context.Background(), &backend.RunStreamRequest{
+			Path: "query/A",
+			Data: []byte(`{ "refId": "A", "rawSql": "EXEC report" }`),
+		}, backend.NewStreamSender(fake))
+		require.NoError(t, err)
+		return fake.frames
+	}
+
+	t.Run("streams every result set", func(t *testing.T) {
+		frames := stream(t, "stream-result-sets", -1)
+		require.Len(t, frames, 3)
+		for i, want := range []struct {
+			name  string
+			field string
+			rows  int
+		}{{"A", "id", 2}, {"A-1", "amount", 2}, {"A-1", "amount", 1}} {
+			assert.Equal(t, want.name, frames[i].Name, "frame %d", i)
+			assert.Equal(t, want.field, frames[i].Fields[0].Name, "frame %d", i)
+			assert.Equal(t, want.rows, frames[i].Rows(), "frame %d", i)
+		}
+	})
+
+	t.Run("row limit applies across result sets", func(t *testing.T) {
+		frames := stream(t, "stream-result-sets-limit", 3)
+		require.Len(t, frames, 2)
+		assert.Equal(t, "A-1", frames[1].Name)
+		assert.Equal(t, 1, frames[1].Rows())
+		require.Len(t, frames[1].Meta.Notices, 1)
+		assert.Contains(t, frames[1].Meta.Notices[0].Text, "limited to 3")
+	})
+}
+
+func TestRunStream_DynamicConverter(t *testing.T) {
+	ds := streamDatasource(t, "stream-dynamic", 5, `{ "streamBatchSize": 2 }`, sqlutil.Converter{Dynamic: true})
+	fake := &fakeStreamSender{}
+
+	err := ds.RunStream(context.Background(), &backend.RunStreamRequest{
+		Path: "query/A",
+		Data: []byte(`{ "refId": "A", "rawSql": "SELECT * FROM test" }`),
+	}, backend.NewStreamSender(fake))
+	require.NoError(t, err)
+
+	require.Len(t, fake.frames, 3)
+	for i, want := range []int{2, 2, 1} {
+		assert.Equal(t, want, fake.frames[i].Rows(), "frame %d", i)
+		assert.Len(t, fake.frames[i].Fields, 2)
+	}
+}
+
+func TestRunStream_ConversionErrorHasSource(t *testing.T) {
+	failing := sqlutil.Converter{
+		Name:          "failing",
+		InputScanType: reflect.TypeOf(int64(0)),
+		InputTypeName: "INTEGER",
+		FrameConverter: sqlutil.FrameConverter{
+			FieldType: data.FieldTypeInt64,
+			ConverterFunc: func(any) (any, error) {
+				return nil, errors.New("bad value")
+			},
+		},
+	}
+	ds := streamDatasource(t, "stream-conversion-error", 1, `{}`, failing)
+
+	err := ds.RunStream(context.Background(), &backend.RunStreamRequest{
+		Path: "query/A",
+		Data: []byte(`{ "refId": "A", "rawSql": "SELECT * FROM test" }`),
+	}, backend.NewStreamSender(&fakeStreamSender{}))
+	require.ErrorContains(t, err, "Could not process SQL results")
+	var withSource backend.ErrorWithSource
+	require.ErrorAs(t, err, &withSource)
+	assert.Equal(t, backend.ErrorSourcePlugin, withSource.ErrorSource())
+}
+
+func TestRunStream_Disabled(t *testing.T) {
+	ds := streamDatasource(t, "stream-disabled", 1, `{}`)
+	ds.EnableStreaming = false
+
+	err := ds.RunStream(context.Background(), &backend.RunStreamRequest{Path: "query/A"}, backend.NewStreamSender(&fakeStreamSender{}))
+	assert.ErrorIs(t, err, sqlds.ErrorStreamingDisabled)
+
+	res, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: "query/A"})
+	require.NoError(t, err)
+	assert.Equal(t, backend.SubscribeStreamStatusNotFound, res.Status)
+}
+
+func TestSubscribeStream_Paths(t *testing.T) {
+	ds := streamDatasource(t, "stream-subscribe", 1, `{}`)
+
+	res, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: "query/A"})
+	require.NoError(t, err)
+	assert.Equal(t, backend.SubscribeStreamStatusOK, res.Status)
+
+	res, err = ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: "other"})
+	require.NoError(t, err)
+	assert.Equal(t, backend.SubscribeStreamStatusNotFound, res.Status)
+}
@@ -62,6 +62,7 @@ type SqlHandler struct {
 	Opts  DriverOpts
 	State State
 	row   int
+	set   int
 }
 
 // Ping represents a database ping
@@ -83,6 +84,7 @@ func (s *SqlHandler) Query(args []driver.Value) (driver.Rows, error) {
 		time.Sleep(time.Duration(s.Opts.QueryDelay * int(time.Second))) // simulate a query delay
 	}
 	s.row = 0
+	s.set = 0
 	// only show the error if we have not exceeded the fail times and the error is not nil
 	if s.Opts.QueryError != nil && (s.Opts.QueryFailTimes == 0 || s.State.QueryAttempts <= s.Opts.QueryFailTimes) {
 		return s, s.Opts.QueryError
@@ -94,7 +96,7 @@ func (s *SqlHandler) Query(args []driver.Value) (driver.Rows, error) {
 // Columns represents columns from a query
 func (s *SqlHandler) Columns() []string {
 	var cols []string
-	for _, c := range s.Data.Cols {
+	for _, c := range s.current().Cols {
 		cols = append(cols, c.Name)
 	}
 	return cols
@@ -102,11 +104,12 @@ func (s *SqlHandler) Columns() []string {
 
 // Next iterates over rows
 func (s *SqlHandler) Next(dest []driver.Value) error {
-	if s.row+1 > len(s.Data.Rows) {
+	rows := s.current().Rows
+	if s.row+1 > len(rows) {
 		return io.EOF
 	}
 
-	row := s.Data.Rows[s.row]
+	row := rows[s.row]
 	s.row++
 
 	for i, col := range row {
@@ -115,6 +118,29 @@ func (s *SqlHandler) Next(dest []driver.Value) error {
 	return nil
 }
 
+// HasNextResultSet reports whether another result set follows the current one
+func (s *SqlHandler) HasNextResultSet() bool {
+	return s.set < len(s.Opts.ResultSets)
+}
+
+// NextResultSet advances to the next result set
+func (s *SqlHandler) NextResultSet() error {
+	if !s.HasNextResultSet() {
+		return io.EOF
+	}
+	s.set++
+	s.row = 0
+	return nil
+}
+
+// current returns the result set being read
+func (s SqlHandler) current() Data {
+	if s.set == 0 {
+		return s.Data
+	}
+	return s.Opts.ResultSets[s.set-1]
+}
+
 // Close implements the database Close interface
 func (s SqlHandler) Close() error {
 	return nil
@@ -122,13 +148,13 @@ func (s SqlHandler) Close() error {
 
 // ColumnTypeScanType returns the scan type for the column
 func (s SqlHandler) ColumnTypeScanType(index int) reflect.Type {
-	kind := s.Data.Cols[index].Kind
+	kind := s.current().Cols[index].Kind
 	return reflect.TypeOf(kind)
 }
 
 // ColumnTypeDatabaseTypeName returns the database type for the column
 func (s SqlHandler) ColumnTypeDatabaseTypeName(index int) string {
-	return s.Data.Cols[index].DataType
+	return s.current().Cols[index].DataType
 }
 
 // Data - the columns/rows
@@ -191,6 +217,8 @@ type DriverOpts struct {
 	QueryDelay       int
 	QueryError       error
 	QueryFailTimes   int
+	// ResultSets are returned, in order, as further result sets after Data
+	ResultSets []Data
 }
 
 // State is the state of the connections/queries
@@ -0,0 +1,72 @@
+package sqlds
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attributes set on the spans of the query pipeline.
+const (
+	attributeRefID         = attribute.Key("sqlds.ref_id")
+	attributeFormat        = attribute.Key("sqlds.format")
+	attributeRows          = attribute.Key("sqlds.rows")
+	attributeConnectionKey = attribute.Key("sqlds.connection_key_hash")
+	attributeRetryAttempt  = attribute.Key("sqlds.retry_attempt")
+)
+
+// startSpan starts the span of a stage of the query pipeline, named
+// sqlds.<stage>, with the tracer the plugin SDK is configured with. Without a
+// configured tracer the span is a no-op.
+func startSpan(ctx context.Context, stage string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracing.DefaultTracer().Start(ctx, "sqlds."+stage, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		tracing.Error(span, err)
+	}
+	span.End()
+}
+
+// endMutatorSpan ends the span of a mutator stage and returns the context the
+// mutator returned with the span of parent restored, so that the stages
+// after the mutator are not parented to its ended span.
+func endMutatorSpan(parent, ctx context.Context, span trace.Span) context.Context {
+	span.End()
+	return trace.ContextWithSpan(ctx, trace.SpanFromContext(parent))
+}
+
+func connectionKeyAttribute(key string) attribute.KeyValue {
+	return attributeConnectionKey.String(connectionKeyHash(key))
+}
+
+// connectionKeyHash identifies the connection a query runs on without
+// revealing its key, which embeds the datasource UID.
+func connectionKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// formatAttribute names the format of a query the way the query JSON does.
+func formatAttribute(format sqlutil.FormatQueryOption) attribute.KeyValue {
+	switch format {
+	case sqlutil.FormatOptionTimeSeries:
+		return attributeFormat.String("timeseries")
+	case sqlutil.FormatOptionTable:
+		return attributeFormat.String("table")
+	case sqlutil.FormatOptionLogs:
+		return attributeFormat.String("logs")
+	case sqlutil.FormatOptionTrace:
+		return attributeFormat.String("traces")
+	case sqlutil.FormatOptionMulti:
+		return attributeFormat.String("multi")
+	}
+	return attributeFormat.Int64(int64(format))
+}
@@ -0,0 +1,108 @@
+package sqlds_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/sqlds/v5"
+	"github.com/grafana/sqlds/v5/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	previous := tracing.DefaultTracer()
+	tracing.InitDefaultTracer(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test"))
+	t.Cleanup(func() { tracing.InitDefaultTracer(previous) })
+	return recorder
+}
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestQueryData_Spans(t *testing.T) {
+	recorder := recordSpans(t)
+
+	rows := test.Data{
+		Cols: []test.Column{{Name: "v", DataType: "INTEGER", Kind: int64(0)}},
+		Rows: [][]any{{int64(1)}, {int64(2)}},
+	}
+	name := "query-spans"
+	driver, _ := test.NewDriver(name, rows, nil, test.DriverOpts{}, nil)
+	ds := sqlds.NewDatasource(driver)
+
+	settings := backend.DataSourceInstanceSettings{UID: name, JSONData: []byte("{}")}
+	_, err := ds.NewDatasource(context.Background(), settings)
+	require.NoError(t, err)
+
+	req := &backend.QueryDataRequest{
+		PluginContext: backend.PluginContext{DataSourceInstanceSettings: &settings},
+		Queries: []backend.DataQuery{
+			{RefID: "A", JSON: []byte(`{ "rawSql": "SELECT v", "format": 1 }`)},
+		},
+	}
+	res, err := ds.QueryData(context.Background(), req)
+	require.NoError(t, err)
+	require.NoError(t, res.Responses["A"].Error)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	for _, name := range []string{"sqlds.query", "sqlds.GetQuery", "sqlds.interpolate", "sqlds.GetConnectionFromQuery", "sqlds.QueryContext", "sqlds.FrameFromRows"} {
+		span, ok := spans[name]
+		require.True(t, ok, "expected span %s", name)
+		assert.Equal(t, "A", spanAttributes(span)["sqlds.ref_id"].AsString(), name)
+		if name != "sqlds.query" {
+			assert.Equal(t, spans["sqlds.query"].SpanContext().SpanID(), span.Parent().SpanID(), name)
+		}
+	}
+
+	query := spanAttributes(spans["sqlds.query"])
+	assert.Equal(t, "table", query["sqlds.format"].AsString())
+	assert.NotEmpty(t, query["sqlds.connection_key_hash"].AsString())
+	assert.Equal(t, int64(2), spanAttributes(spans["sqlds.FrameFromRows"])["sqlds.rows"].AsInt64())
+}
+
+func TestQueryData_RetrySpans(t *testing.T) {
+	recorder := recordSpans(t)
+
+	opts := test.DriverOpts{
+		QueryError: transientError{},
+	}
+	name := "retry-spans"
+	driver, _ := test.NewDriver(name, test.Data{}, nil, opts, nil)
+	ds := sqlds.NewDatasource(driver)
+
+	req, settings := setupQueryRequest(name, `{ "retries": 2, "retryOn": ["transient"] }`)
+	_, err := ds.NewDatasource(context.Background(), settings)
+	require.NoError(t, err)
+
+	_, err = ds.QueryData(context.Background(), req)
+	require.NoError(t, err)
+
+	var attempts []int64
+	reconnects := 0
+	for _, span := range recorder.Ended() {
+		switch span.Name() {
+		case "sqlds.retry":
+			attempts = append(attempts, spanAttributes(span)["sqlds.retry_attempt"].AsInt64())
+		case "sqlds.Reconnect":
+			reconnects++
+		}
+	}
+	assert.ElementsMatch(t, []int64{1, 2}, attempts)
+	assert.Equal(t, 2, reconnects)
+}